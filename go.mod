@@ -4,7 +4,6 @@ go 1.24.0
 
 require (
 	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b
+	golang.org/x/sys v0.33.0
 	golang.org/x/term v0.32.0
 )
-
-require golang.org/x/sys v0.33.0 // indirect
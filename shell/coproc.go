@@ -0,0 +1,127 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// coproc is a background job whose input and output are pipes held by the
+// shell, on the descriptors in NAME[1] and NAME[0].
+type coproc struct {
+	job     *Job
+	readFd  int // what the coprocess prints
+	writeFd int // what it reads
+	files   []*os.File
+}
+
+// firstCoprocFd is where the search for free descriptors starts, clear of
+// the ones scripts pick for themselves.
+const firstCoprocFd = 10
+
+// startCoproc starts cmdline as a job connected to the shell by two pipes,
+// and sets NAME and NAME_PID. A coprocess left over under the same name has
+// its descriptors closed.
+func (sh *Interpreter) startCoproc(name, cmdline, text string) int {
+	// NAME becomes an array, which would hide the variable
+	if sh.restrictedVar(name) {
+		fmt.Fprintf(sh.fds.writer(2), "%scoproc: %s: readonly variable\n", sh.location(), name)
+		return 1
+	}
+	if old, ok := sh.coprocs[name]; ok {
+		sh.closeCoproc(name, old)
+	}
+	cmds := sh.parsePipeline(cmdline)
+	if err := sh.expansionError(); err != nil {
+		return sh.failExpansion(err)
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(sh.fds.writer(2), "coproc: %s\n", err)
+		return 1
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		fmt.Fprintf(sh.fds.writer(2), "coproc: %s\n", err)
+		return 1
+	}
+	base := sh.fds.Clone()
+	base[0], base[1] = inR, outW
+	j := sh.newJob(text, sh.startPipeline(cmds, base, false))
+
+	// External stages have their own copies of the coprocess's ends. A
+	// builtin stage uses ours until it is done.
+	release := func(f *os.File, argv []word) {
+		if !sh.stageInShell(argv) {
+			f.Close()
+			return
+		}
+		go func() {
+			<-j.done
+			f.Close()
+		}()
+	}
+	release(inR, cmds[0])
+	release(outW, cmds[len(cmds)-1])
+
+	c := &coproc{job: j, files: []*os.File{outR, inW}}
+	c.readFd = freeFd(sh.fds, firstCoprocFd)
+	sh.fds[c.readFd] = outR
+	c.writeFd = freeFd(sh.fds, firstCoprocFd)
+	sh.fds[c.writeFd] = inW
+	sh.coprocs[name] = c
+	sh.arrays[name] = []string{strconv.Itoa(c.readFd), strconv.Itoa(c.writeFd)}
+
+	jobsMu.Lock()
+	sh.addJob(j)
+	jobsMu.Unlock()
+	if pids := j.p.pids; len(pids) > 0 {
+		sh.lastBgPid = pids[len(pids)-1]
+		sh.setVar(name+"_PID", strconv.Itoa(sh.lastBgPid))
+		if sh.interactive {
+			fmt.Fprintf(sh.fds.writer(2), "[%d] %d\n", j.id, sh.lastBgPid)
+		}
+	}
+	return 0
+}
+
+// closeCoproc closes the shell's ends of a coprocess's pipes and forgets its
+// variables. The coprocess itself sees end of file and is left to finish.
+func (sh *Interpreter) closeCoproc(name string, c *coproc) {
+	for i, fd := range []int{c.readFd, c.writeFd} {
+		// Unless exec has already put something else there
+		if sh.fds[fd] == c.files[i] {
+			delete(sh.fds, fd)
+		}
+		c.files[i].Close()
+	}
+	delete(sh.coprocs, name)
+	// Only the array: a variable of the same name is the user's own
+	delete(sh.arrays, name)
+	sh.unsetVar(name + "_PID")
+}
+
+// coprocFd reports whether fd is where the shell keeps one of a
+// coprocess's pipes, holding f. As in bash, those stay the shell's own: a
+// command only gets one through a redirection that copies it elsewhere.
+func (sh *Interpreter) coprocFd(fd int, f *os.File) bool {
+	for _, c := range sh.coprocs {
+		if (fd == c.readFd && f == c.files[0]) || (fd == c.writeFd && f == c.files[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeFd returns the lowest descriptor from min up that t doesn't use.
+func freeFd(t FdTable, min int) int {
+	fd := min
+	for {
+		if _, used := t[fd]; !used {
+			return fd
+		}
+		fd++
+	}
+}
@@ -0,0 +1,255 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shellVar is a shell variable. Exported ones make up the environment of
+// every command the shell starts.
+type shellVar struct {
+	value    string
+	exported bool
+	set      bool // false for a name that was exported before being given a value
+}
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// importEnv turns NAME=value strings into exported variables of i.
+func importEnv(i *Interpreter, env []string) {
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && nameRe.MatchString(name) {
+			i.vars[name] = &shellVar{value: value, exported: true, set: true}
+		}
+	}
+}
+
+// initVars sets up the variables a new interpreter maintains itself.
+func initVars(i *Interpreter) {
+	level := 0
+	if v, ok := i.vars["SHLVL"]; ok {
+		level, _ = strconv.Atoi(v.value)
+	}
+	i.vars["SHLVL"] = &shellVar{value: strconv.Itoa(level + 1), exported: true, set: true}
+	if i.dir != "" {
+		i.vars["PWD"] = &shellVar{value: i.dir, exported: true, set: true}
+	}
+}
+
+func (sh *Interpreter) lookupShellVar(name string) (string, bool) {
+	if v, ok := sh.vars[name]; ok && v.set {
+		return v.value, true
+	}
+	return "", false
+}
+
+func (sh *Interpreter) getVar(name string) string {
+	value, _ := sh.lookupShellVar(name)
+	return value
+}
+
+func (sh *Interpreter) setVar(name, value string) {
+	if v, ok := sh.vars[name]; ok {
+		v.value, v.set = value, true
+		return
+	}
+	sh.vars[name] = &shellVar{value: value, set: true}
+}
+
+func (sh *Interpreter) exportVar(name string) {
+	if v, ok := sh.vars[name]; ok {
+		v.exported = true
+		return
+	}
+	sh.vars[name] = &shellVar{exported: true}
+}
+
+func (sh *Interpreter) unsetVar(name string) {
+	delete(sh.vars, name)
+	delete(sh.arrays, name)
+}
+
+// exportedEnv returns the environment for a child process: the exported
+// variables, overridden by any NAME=value entries in extra.
+func (sh *Interpreter) exportedEnv(extra ...string) []string {
+	env := map[string]string{}
+	for name, v := range sh.vars {
+		if v.exported && v.set {
+			env[name] = v.value
+		}
+	}
+	for _, kv := range extra {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+	list := make([]string, 0, len(env))
+	for name, value := range env {
+		list = append(list, name+"="+value)
+	}
+	slices.Sort(list)
+	return list
+}
+
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	return ok && nameRe.MatchString(name)
+}
+
+// splitAssignments separates the NAME=value words in front of a command from the command itself.
+func splitAssignments(argv []string) ([]string, []string) {
+	i := 0
+	for i < len(argv) && isAssignment(argv[i]) {
+		i++
+	}
+	return argv[:i], argv[i:]
+}
+
+// withAssignments runs fn with NAME=value prefixes exported for its duration,
+// then puts the variables back the way they were.
+func (sh *Interpreter) withAssignments(assigns []string, fn func() int) int {
+	saved := map[string]*shellVar{}
+	for _, kv := range assigns {
+		name, value, _ := strings.Cut(kv, "=")
+		if _, done := saved[name]; !done {
+			if v, ok := sh.vars[name]; ok {
+				copied := *v
+				saved[name] = &copied
+			} else {
+				saved[name] = nil
+			}
+		}
+		sh.vars[name] = &shellVar{value: value, exported: true, set: true}
+	}
+	defer func() {
+		for name, v := range saved {
+			if v == nil {
+				delete(sh.vars, name)
+			} else {
+				sh.vars[name] = v
+			}
+		}
+	}()
+	return fn()
+}
+
+func (sh *Interpreter) ExportCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	unexport := false
+	var names []string
+	for _, arg := range argv[1:] {
+		switch arg {
+		case "-n":
+			unexport = true
+		case "-p":
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 {
+		var exported []string
+		for name, v := range sh.vars {
+			if v.exported {
+				exported = append(exported, name)
+			}
+		}
+		slices.Sort(exported)
+		for _, name := range exported {
+			if v := sh.vars[name]; v.set {
+				fmt.Fprintf(out, "declare -x %s=%s\n", name, quoteWord(v.value))
+			} else {
+				fmt.Fprintf(out, "declare -x %s\n", name)
+			}
+		}
+		return 0
+	}
+	status := 0
+	for _, arg := range names {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !nameRe.MatchString(name) {
+			fmt.Fprintf(errOut, "export: `%s': not a valid identifier\n", arg)
+			status = 1
+			continue
+		}
+		if sh.restrictedVar(name) && (hasValue || unexport) {
+			fmt.Fprintf(errOut, "export: %s: readonly variable\n", name)
+			status = 1
+			continue
+		}
+		if hasValue {
+			sh.setVar(name, value)
+		}
+		if unexport {
+			if v, ok := sh.vars[name]; ok {
+				v.exported = false
+			}
+		} else {
+			sh.exportVar(name)
+		}
+	}
+	return status
+}
+
+func (sh *Interpreter) UnsetCommand(argv []string, fds FdTable) int {
+	errOut := fds.writer(2)
+	status := 0
+	funcs := false
+	for _, name := range argv[1:] {
+		switch name {
+		case "-v":
+			funcs = false
+			continue
+		case "-f":
+			funcs = true
+			continue
+		}
+		if funcs {
+			delete(sh.functions, name)
+			continue
+		}
+		if !nameRe.MatchString(name) {
+			fmt.Fprintf(errOut, "unset: `%s': not a valid identifier\n", name)
+			status = 1
+			continue
+		}
+		if sh.restrictedVar(name) {
+			fmt.Fprintf(errOut, "unset: %s: cannot unset: readonly variable\n", name)
+			status = 1
+			continue
+		}
+		sh.unsetVar(name)
+	}
+	return status
+}
+
+// EnvCommand prints the environment children would get, after -i, -u NAME
+// and NAME=value changes. Given a command, it runs it in that environment.
+func (sh *Interpreter) EnvCommand(argv []string, fds FdTable) int {
+	env := sh.exportedEnv()
+	i := 1
+	for ; i < len(argv); i++ {
+		arg := argv[i]
+		switch {
+		case arg == "-i" || arg == "-":
+			env = nil
+		case arg == "-u" && i+1 < len(argv):
+			i++
+			env = slices.DeleteFunc(env, func(kv string) bool { return strings.HasPrefix(kv, argv[i]+"=") })
+		case isAssignment(arg):
+			name, _, _ := strings.Cut(arg, "=")
+			env = slices.DeleteFunc(env, func(kv string) bool { return strings.HasPrefix(kv, name+"=") })
+			env = append(env, arg)
+		default:
+			return sh.runExternal(argv[i:], env, fds)
+		}
+	}
+	out := fds.writer(1)
+	for _, kv := range env {
+		fmt.Fprintln(out, kv)
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// shellFunc is a function defined with name() { ... }.
+type shellFunc struct {
+	name string
+	body *listNode
+	text string // the definition as it was typed, for type
+}
+
+// callFunction runs fn with argv[1:] as its positional parameters and fds as
+// the descriptors its commands start from.
+func (sh *Interpreter) callFunction(fn *shellFunc, argv []string, fds FdTable) int {
+	savedArgs, savedFds := sh.args, sh.fds
+	sh.args, sh.fds = argv[1:], fds.Clone()
+	sh.funcDepth++
+	defer func() {
+		sh.args = savedArgs
+		sh.restoreFds(savedFds, fds)
+		sh.funcDepth--
+		sh.returning = false
+	}()
+	sh.lastStatus = 0
+	return sh.runList(fn.body)
+}
+
+func (sh *Interpreter) ReturnCommand(argv []string, fds FdTable) int {
+	errOut := fds.writer(2)
+	if sh.funcDepth == 0 && sh.sourceDepth == 0 {
+		fmt.Fprintf(errOut, "%sreturn: can only `return' from a function or sourced script\n", sh.location())
+		return 2
+	}
+	sh.returning = true
+	if len(argv) < 2 {
+		return sh.lastStatus
+	}
+	code, err := strconv.Atoi(argv[1])
+	if err != nil {
+		fmt.Fprintf(errOut, "return: %s: numeric argument required\n", argv[1])
+		return 2
+	}
+	return code & 0xff
+}
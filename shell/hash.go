@@ -0,0 +1,160 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// hashEntry is where a command was last found, and how often it was used since.
+type hashEntry struct {
+	path string
+	hits int
+}
+
+var hashMu sync.Mutex
+
+// hashedCommand returns the remembered location of cmd. An entry whose file
+// has gone away is forgotten.
+func (sh *Interpreter) hashedCommand(cmd string) (string, bool) {
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	if path := sh.getVar("PATH"); path != sh.hashedPath {
+		clear(sh.commandHash)
+		sh.hashedPath = path
+	}
+	e, ok := sh.commandHash[cmd]
+	if !ok {
+		return "", false
+	}
+	if info, err := os.Stat(sh.resolve(e.path)); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		delete(sh.commandHash, cmd)
+		return "", false
+	}
+	e.hits++
+	return e.path, true
+}
+
+func (sh *Interpreter) rememberCommand(cmd, path string, hits int) {
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	sh.commandHash[cmd] = &hashEntry{path: path, hits: hits}
+}
+
+func (sh *Interpreter) HashCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	var reset, remove, show, reuse bool
+	var path string
+	i := 1
+flags:
+	for ; i < len(argv) && strings.HasPrefix(argv[i], "-"); i++ {
+		switch argv[i] {
+		case "-r":
+			reset = true
+		case "-d":
+			remove = true
+		case "-t":
+			show = true
+		case "-l":
+			reuse = true
+		case "-p":
+			if i+1 >= len(argv) {
+				fmt.Fprintln(errOut, "hash: -p: option requires an argument")
+				return 2
+			}
+			i++
+			path = argv[i]
+		case "--":
+			i++
+			break flags
+		default:
+			fmt.Fprintf(errOut, "hash: %s: invalid option\n", argv[i])
+			return 2
+		}
+	}
+	names := argv[i:]
+	sh.hashedCommand("") // drop a table that belongs to an old PATH
+	if reset {
+		hashMu.Lock()
+		clear(sh.commandHash)
+		hashMu.Unlock()
+	}
+	if len(names) == 0 {
+		if path != "" || remove || show {
+			fmt.Fprintln(errOut, "hash: usage: hash [-lr] [-p pathname] [-dt] [name ...]")
+			return 2
+		}
+		if !reset {
+			sh.printHash(out, reuse)
+		}
+		return 0
+	}
+
+	status := 0
+	for _, name := range names {
+		switch {
+		case path != "":
+			sh.rememberCommand(name, path, 0)
+		case remove:
+			hashMu.Lock()
+			_, ok := sh.commandHash[name]
+			delete(sh.commandHash, name)
+			hashMu.Unlock()
+			if !ok {
+				fmt.Fprintf(errOut, "hash: %s: not found\n", name)
+				status = 1
+			}
+		case show:
+			hashMu.Lock()
+			e, ok := sh.commandHash[name]
+			hashMu.Unlock()
+			if !ok {
+				fmt.Fprintf(errOut, "hash: %s: not found\n", name)
+				status = 1
+			} else if len(names) > 1 {
+				fmt.Fprintf(out, "%s\t%s\n", name, e.path)
+			} else {
+				fmt.Fprintln(out, e.path)
+			}
+		case isBuiltin(name) || strings.Contains(name, "/"):
+			// Nothing to remember
+		default:
+			file, ok := sh.findBinInPath(name)
+			if !ok {
+				fmt.Fprintf(errOut, "hash: %s: not found\n", name)
+				status = 1
+				continue
+			}
+			sh.rememberCommand(name, file, 0)
+		}
+	}
+	return status
+}
+
+func (sh *Interpreter) printHash(out io.Writer, reuse bool) {
+	hashMu.Lock()
+	defer hashMu.Unlock()
+	if len(sh.commandHash) == 0 {
+		fmt.Fprintln(out, "hash: hash table empty")
+		return
+	}
+	names := make([]string, 0, len(sh.commandHash))
+	for name := range sh.commandHash {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if !reuse {
+		fmt.Fprintln(out, "hits\tcommand")
+	}
+	for _, name := range names {
+		e := sh.commandHash[name]
+		if reuse {
+			fmt.Fprintf(out, "hash -p %s %s\n", e.path, name)
+		} else {
+			fmt.Fprintf(out, "%4d\t%s\n", e.hits, e.path)
+		}
+	}
+}
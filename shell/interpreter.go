@@ -0,0 +1,360 @@
+// Package shell is a POSIX-style shell, usable as a program through Main or
+// embedded in another program through Interpreter. A program that embeds it
+// should call MaybeTrampoline first thing in main.
+package shell
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// Interpreter is one shell session: its variables, functions, options, jobs,
+// history and descriptors. Interpreters can share a process and run at the
+// same time, but each runs one thing at a time: a Run or RunFile waits for
+// the one before it on the same interpreter to finish. Only the shell run by
+// Main changes the process's working directory and signal handling.
+type Interpreter struct {
+	mu sync.Mutex // held while a Run, RunFile or Close runs
+
+	vars      map[string]*shellVar
+	arrays    map[string][]string // indexed arrays the shell sets itself, like a coprocess's descriptors
+	functions map[string]*shellFunc
+	options   []*shellOption
+	fds       FdTable // every command starts from these; exec with only redirections changes them
+	stdio     FdTable // fds as New set them up, which are the caller's to close
+	dir       string  // working directory, which relative paths are resolved against
+
+	name       string   // $0
+	args       []string // $1, $2, ...
+	scriptName string   // the file being run or sourced, for error messages
+	lineNo     int      // where in it the running command starts
+	lastStatus int      // $?
+	pipeStatus []int    // PIPESTATUS
+	lastBgPid  int      // $!
+
+	hist         *History
+	histFile     string
+	historyIndex int
+	trie         *Trie
+	interactive  bool // reading commands from the terminal
+	standalone   bool // the process is this shell, so exec may replace it
+
+	// commandHash remembers command locations so PATH isn't searched every
+	// time. It is emptied whenever PATH differs from hashedPath.
+	commandHash map[string]*hashEntry
+	hashedPath  string
+
+	jobs    []*Job
+	jobSeq  int
+	coprocs map[string]*coproc
+	procs   map[*os.Process]bool // running children, guarded by jobsMu
+	timing  *usageTotal          // the time keyword that is running, if any; pipelines report to it
+	sandbox *sandboxPolicy       // what sandboxed commands may do, when not the default
+
+	// traps maps a signal name without its SIG prefix, or one of the pseudo
+	// signals EXIT, ERR and DEBUG, to the commands to run for it. An empty
+	// action ignores the signal.
+	traps  map[string]string
+	inTrap int // how many trap actions are running
+
+	funcDepth        int  // how many function calls are running
+	sourceDepth      int  // how many sourced files are running
+	returning        bool // set by return until the function or sourced file unwinds
+	inNotFoundHandle bool
+	loopDepth        int
+	breakLevels      int // loops that break still has to leave
+	continueLevels   int // loops continue still has to unwind, counting the one it continues
+
+	// errexitIgnored is above zero while running commands whose failure set -e
+	// must not act on: conditions, all but the last part of an and-or list,
+	// and negated pipelines.
+	errexitIgnored int
+
+	// interrupted is set when a foreground job dies of SIGINT or an expansion
+	// fails, and stops the rest of the command line.
+	interrupted bool
+
+	// unboundVar is the first unset variable an expansion used while set -u
+	// was on.
+	unboundVar string
+
+	// exiting is set by exit, and unwinds everything up to the Run that
+	// called it, which returns exitStatus.
+	exiting    bool
+	exitStatus int
+
+	ctx context.Context
+}
+
+// An Option configures a new Interpreter.
+type Option func(*Interpreter)
+
+// WithStdio sets the standard input, output and error that commands start
+// with. By default they are the process's own.
+func WithStdio(in io.Reader, out, errOut io.Writer) Option {
+	return func(i *Interpreter) {
+		i.fds[0] = asInput(in)
+		i.fds[1] = asOutput(out)
+		i.fds[2] = asOutput(errOut)
+	}
+}
+
+// WithEnv sets the environment the variables are imported from, as
+// NAME=value strings. By default it is the process's environment.
+func WithEnv(env []string) Option {
+	return func(i *Interpreter) {
+		i.vars = map[string]*shellVar{}
+		importEnv(i, env)
+	}
+}
+
+// WithDir sets the directory commands start in. By default it is the
+// process's working directory when New is called.
+func WithDir(dir string) Option {
+	return func(i *Interpreter) {
+		i.dir = dir
+	}
+}
+
+// WithHistory gives the interpreter a history to add to and read from, in
+// place of an empty one of its own.
+func WithHistory(h *History) Option {
+	return func(i *Interpreter) {
+		i.hist = h
+	}
+}
+
+// New returns an interpreter that isn't interactive, set up by opts.
+func New(opts ...Option) *Interpreter {
+	i := &Interpreter{
+		vars:        map[string]*shellVar{},
+		arrays:      map[string][]string{},
+		functions:   map[string]*shellFunc{},
+		options:     newOptions(),
+		fds:         defaultFds(),
+		name:        "shell",
+		hist:        &History{},
+		commandHash: map[string]*hashEntry{},
+		coprocs:     map[string]*coproc{},
+		procs:       map[*os.Process]bool{},
+		traps:       map[string]string{},
+	}
+	i.dir, _ = os.Getwd()
+	importEnv(i, os.Environ())
+	for _, opt := range opts {
+		opt(i)
+	}
+	i.stdio = i.fds.Clone()
+	initVars(i)
+	return i
+}
+
+// subshell returns a copy of sh for commands that must not change it, like
+// the builtins in a pipeline or in the background. Whatever they set, exit
+// or change directory to stays in the copy. The children it starts are
+// still sh's to kill, and it never touches the process's directory or
+// signal handling.
+func (sh *Interpreter) subshell() *Interpreter {
+	sub := &Interpreter{
+		vars:           make(map[string]*shellVar, len(sh.vars)),
+		arrays:         make(map[string][]string, len(sh.arrays)),
+		functions:      maps.Clone(sh.functions),
+		fds:            sh.fds.Clone(),
+		dir:            sh.dir,
+		name:           sh.name,
+		args:           sh.args,
+		scriptName:     sh.scriptName,
+		lineNo:         sh.lineNo,
+		lastStatus:     sh.lastStatus,
+		pipeStatus:     sh.pipeStatus,
+		lastBgPid:      sh.lastBgPid,
+		hist:           &History{Entries: slices.Clone(sh.hist.Entries), lastAppendedIndex: sh.hist.lastAppendedIndex},
+		histFile:       sh.histFile,
+		commandHash:    map[string]*hashEntry{},
+		coprocs:        maps.Clone(sh.coprocs),
+		procs:          sh.procs,
+		timing:         sh.timing,
+		sandbox:        sh.sandbox,
+		traps:          maps.Clone(sh.traps),
+		inTrap:         sh.inTrap,
+		funcDepth:      sh.funcDepth,
+		sourceDepth:    sh.sourceDepth,
+		loopDepth:      sh.loopDepth,
+		errexitIgnored: sh.errexitIgnored,
+		ctx:            sh.ctx,
+	}
+	for name, v := range sh.vars {
+		copied := *v
+		sub.vars[name] = &copied
+	}
+	for name, a := range sh.arrays {
+		sub.arrays[name] = slices.Clone(a)
+	}
+	for _, o := range sh.options {
+		copied := *o
+		sub.options = append(sub.options, &copied)
+	}
+	jobsMu.Lock()
+	sub.jobs, sub.jobSeq = slices.Clone(sh.jobs), sh.jobSeq
+	jobsMu.Unlock()
+	hashMu.Lock()
+	for cmd, e := range sh.commandHash {
+		copied := *e
+		sub.commandHash[cmd] = &copied
+	}
+	sub.hashedPath = sh.hashedPath
+	hashMu.Unlock()
+	return sub
+}
+
+// Run runs src as a script and returns the status of its last command.
+// Cancelling ctx kills the commands it started and stops it before the next
+// one, in which case ctx's error is returned along with the status.
+func (i *Interpreter) Run(ctx context.Context, src string) (int, error) {
+	return i.run(ctx, func() { i.runSource(src) })
+}
+
+// RunFile is Run for the script in the file at path, with $0 set to path
+// and args as $1, $2, ... while it runs.
+func (i *Interpreter) RunFile(ctx context.Context, path string, args ...string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 127, err
+	}
+	return i.run(ctx, func() {
+		savedName, savedArgs, savedScript := i.name, i.args, i.scriptName
+		i.name, i.args, i.scriptName = path, args, path
+		defer func() { i.name, i.args, i.scriptName = savedName, savedArgs, savedScript }()
+		i.runSource(string(data))
+	})
+}
+
+// Close runs the EXIT trap, if one is set, then closes the coprocesses'
+// pipes and the files exec left open. The files the interpreter was set up
+// with, and the process's stdio, stay open.
+func (i *Interpreter) Close() error {
+	_, err := i.run(context.Background(), i.runExitTrap)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for name, c := range i.coprocs {
+		i.closeCoproc(name, c)
+	}
+	for fd, f := range i.fds {
+		if file, ok := f.(*os.File); ok && !i.callersFile(file) {
+			file.Close()
+			delete(i.fds, fd)
+		}
+	}
+	return err
+}
+
+// callersFile reports whether f is one of the process's stdio files or one
+// the interpreter was set up with.
+func (i *Interpreter) callersFile(f *os.File) bool {
+	if f == os.Stdin || f == os.Stdout || f == os.Stderr {
+		return true
+	}
+	for _, given := range i.stdio {
+		if given == f {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs fn with ctx as the context of the commands it starts.
+func (i *Interpreter) run(ctx context.Context, fn func()) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.ctx = ctx
+	i.interrupted = false
+	stop := context.AfterFunc(ctx, i.killChildren)
+	defer stop()
+
+	fn()
+	status := i.lastStatus
+	if i.exiting {
+		status = i.exit()
+	}
+	return status, ctx.Err()
+}
+
+// exit finishes what the exit builtin started: it runs the EXIT trap and
+// returns the status to exit with.
+func (i *Interpreter) exit() int {
+	i.exiting = false
+	i.runExitTrap()
+	return i.exitStatus & 0xff
+}
+
+// track records a child that has been started, until it is waited for.
+func (i *Interpreter) track(p *os.Process, running bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if running {
+		i.procs[p] = true
+	} else {
+		delete(i.procs, p)
+	}
+}
+
+func (i *Interpreter) killChildren() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for p := range i.procs {
+		p.Signal(syscall.SIGKILL)
+	}
+}
+
+// resolve makes a relative path relative to the shell's working directory,
+// which is only the process's when the shell is the process.
+func (sh *Interpreter) resolve(path string) string {
+	if filepath.IsAbs(path) || sh.dir == "" {
+		return path
+	}
+	return filepath.Join(sh.dir, path)
+}
+
+// cancelled reports whether the context of the running Run is done.
+func (sh *Interpreter) cancelled() bool {
+	return sh.ctx != nil && sh.ctx.Err() != nil
+}
+
+// inputOnly and outputOnly let the streams given to WithStdio sit in an
+// FdTable.
+type inputOnly struct{ io.Reader }
+
+func (inputOnly) Write([]byte) (int, error) { return 0, errBadFd }
+
+type outputOnly struct{ io.Writer }
+
+func (outputOnly) Read([]byte) (int, error) { return 0, errBadFd }
+
+// asInput and asOutput keep files as they are, so that commands get the
+// descriptors themselves rather than a copy through a pipe. nil leaves the
+// descriptor closed.
+func asInput(r io.Reader) io.ReadWriter {
+	if r == nil {
+		return nil
+	}
+	if f, ok := r.(*os.File); ok {
+		return f
+	}
+	return inputOnly{r}
+}
+
+func asOutput(w io.Writer) io.ReadWriter {
+	if w == nil {
+		return nil
+	}
+	if f, ok := w.(*os.File); ok {
+		return f
+	}
+	return outputOnly{w}
+}
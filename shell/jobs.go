@@ -0,0 +1,479 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
+)
+
+// Job is a running pipeline. It enters the job table when started with a
+// trailing '&' or when it stops in the foreground.
+type Job struct {
+	id       int // 0 until the job is in the table
+	cmdline  string
+	p        *runningPipeline
+	finished bool
+	statuses []int
+	status   int
+	seq      int           // orders jobs for %+ and %-
+	reported JobState      // last state the user was told about
+	done     chan struct{} // closed once every stage has finished
+	ownPid   int           // stands in for a pid when no stage is a process
+}
+
+// ownPids counts the jobs that run only inside the shell. They are
+// numbered above any pid Linux hands out (PID_MAX_LIMIT), so that $! and
+// wait can name them.
+var ownPids atomic.Int64
+
+const pidMaxLimit = 1 << 22
+
+// pid is the process ID that jobs -p and jobs -l show for j.
+func (j *Job) pid() int {
+	if len(j.p.pids) > 0 {
+		return j.p.pids[0]
+	}
+	return j.ownPid
+}
+
+var jobsMu sync.Mutex
+var jobsCond = sync.NewCond(&jobsMu) // signalled whenever a job changes state
+
+// newJob wraps a started pipeline and collects its statuses once it finishes.
+func (sh *Interpreter) newJob(cmdline string, p *runningPipeline) *Job {
+	j := &Job{cmdline: cmdline, p: p, done: make(chan struct{})}
+	go func() {
+		statuses := p.wait()
+		jobsMu.Lock()
+		j.finished = true
+		j.statuses = statuses
+		j.status, _ = sh.pipelineStatus(statuses)
+		jobsCond.Broadcast()
+		jobsMu.Unlock()
+		close(j.done)
+	}()
+	return j
+}
+
+// state is Stopped while any of the job's processes is. Callers hold jobsMu.
+func (j *Job) state() JobState {
+	if j.finished {
+		return JobDone
+	}
+	for _, stopped := range j.p.stopped {
+		if stopped {
+			return JobStopped
+		}
+	}
+	return JobRunning
+}
+
+// addJob puts j in the job table, if it isn't there yet, and makes it the
+// current job. Callers hold jobsMu.
+func (sh *Interpreter) addJob(j *Job) {
+	if j.id == 0 {
+		j.id = 1
+		for _, other := range sh.jobs {
+			if other.id >= j.id {
+				j.id = other.id + 1
+			}
+		}
+		sh.jobs = append(sh.jobs, j)
+	}
+	sh.jobSeq++
+	j.seq = sh.jobSeq
+}
+
+// startJob runs cmdline as a background job, announcing it when the shell
+// is interactive.
+func (sh *Interpreter) startJob(cmdline string) int {
+	base := sh.fds.Clone()
+	var devNull *os.File
+	if !jobControl {
+		// Without job control nothing would stop it from competing for our input
+		if f, err := os.Open(os.DevNull); err == nil {
+			devNull = f
+			base[0] = devNull
+		}
+	}
+	cmds := sh.parsePipeline(cmdline)
+	if err := sh.expansionError(); err != nil {
+		return sh.failExpansion(err)
+	}
+	j := sh.newJob(cmdline, sh.startPipeline(cmds, base, false))
+	if devNull != nil {
+		go func() {
+			<-j.done
+			devNull.Close()
+		}()
+	}
+
+	if pids := j.p.pids; len(pids) > 0 {
+		sh.lastBgPid = pids[len(pids)-1]
+	} else {
+		// Only builtins and functions, which run inside the shell
+		j.ownPid = pidMaxLimit + int(ownPids.Add(1))
+		sh.lastBgPid = j.ownPid
+	}
+	jobsMu.Lock()
+	sh.addJob(j)
+	jobsMu.Unlock()
+
+	if sh.interactive {
+		fmt.Fprintf(sh.fds.writer(2), "[%d] %d\n", j.id, sh.lastBgPid)
+	}
+	return 0
+}
+
+// runForeground waits for j with the terminal handed to its process group,
+// then takes the terminal back. It reports false if j stopped instead of
+// finishing, in which case j is now in the job table.
+func (sh *Interpreter) runForeground(j *Job) bool {
+	if jobControl && j.p.pgid != 0 {
+		setForeground(j.p.pgid)
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for j.state() == JobRunning {
+		jobsCond.Wait()
+	}
+	if jobControl {
+		reclaimTerminal()
+	}
+	if j.state() == JobStopped {
+		sh.addJob(j)
+		j.reported = JobStopped
+		fmt.Fprintf(os.Stderr, "\n%s\n", sh.formatJob(j, false))
+		return false
+	}
+	sh.removeJob(j)
+	if j.status == 128+int(syscall.SIGINT) {
+		fmt.Fprintln(os.Stderr)
+		sh.interrupted = true
+	}
+	return true
+}
+
+// continueJob sends SIGCONT to a stopped job. Callers hold jobsMu.
+func continueJob(j *Job) {
+	if j.state() != JobStopped {
+		return
+	}
+	for pid := range j.p.stopped {
+		j.p.stopped[pid] = false
+	}
+	j.reported = JobRunning
+	if j.p.pgid != 0 && jobControl {
+		syscall.Kill(-j.p.pgid, syscall.SIGCONT)
+		return
+	}
+	for _, pid := range j.p.pids {
+		syscall.Kill(pid, syscall.SIGCONT)
+	}
+}
+
+// currentJobs returns the current (%+) and previous (%-) jobs.
+// Callers hold jobsMu.
+func (sh *Interpreter) currentJobs() (*Job, *Job) {
+	var cur, prev *Job
+	for _, j := range sh.jobs {
+		if cur == nil || j.seq > cur.seq {
+			cur, prev = j, cur
+		} else if prev == nil || j.seq > prev.seq {
+			prev = j
+		}
+	}
+	return cur, prev
+}
+
+// findJob resolves a job spec: %n, %%, %+, %-, %string (command prefix) and
+// %?string (command substring). A bare number is taken as a pid.
+// Callers hold jobsMu.
+func (sh *Interpreter) findJob(spec string) (*Job, error) {
+	cur, prev := sh.currentJobs()
+	if !strings.HasPrefix(spec, "%") {
+		pid, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: no such job", spec)
+		}
+		for _, j := range sh.jobs {
+			if slices.Contains(j.p.pids, pid) || j.ownPid == pid {
+				return j, nil
+			}
+		}
+		return nil, fmt.Errorf("pid %d is not a child of this shell", pid)
+	}
+	s := spec[1:]
+	var found *Job
+	switch {
+	case s == "" || s == "%" || s == "+":
+		found = cur
+	case s == "-":
+		found = prev
+	default:
+		if n, err := strconv.Atoi(s); err == nil {
+			for _, j := range sh.jobs {
+				if j.id == n {
+					found = j
+				}
+			}
+			break
+		}
+		for _, j := range sh.jobs {
+			var match bool
+			if strings.HasPrefix(s, "?") {
+				match = strings.Contains(j.cmdline, s[1:])
+			} else {
+				match = strings.HasPrefix(j.cmdline, s)
+			}
+			if match {
+				if found != nil {
+					return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+				}
+				found = j
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+func (sh *Interpreter) removeJob(job *Job) {
+	sh.jobs = slices.DeleteFunc(sh.jobs, func(j *Job) bool { return j == job })
+}
+
+func (s JobState) String() string {
+	switch s {
+	case JobRunning:
+		return "Running"
+	case JobStopped:
+		return "Stopped"
+	}
+	return "Done"
+}
+
+// describe formats the state column of the jobs listing.
+func (j *Job) describe() string {
+	if j.state() != JobDone || j.status == 0 {
+		return j.state().String()
+	}
+	if j.status > 128 {
+		name := syscall.Signal(j.status - 128).String()
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+	return fmt.Sprintf("Exit %d", j.status)
+}
+
+// formatJob renders one line of jobs output. Callers hold jobsMu.
+func (sh *Interpreter) formatJob(j *Job, long bool) string {
+	cur, prev := sh.currentJobs()
+	mark := " "
+	if j == cur {
+		mark = "+"
+	} else if j == prev {
+		mark = "-"
+	}
+	cmdline := j.cmdline
+	if j.state() == JobRunning {
+		cmdline += " &"
+	}
+	pid := ""
+	if long {
+		pid = strconv.Itoa(j.pid()) + " "
+	}
+	return fmt.Sprintf("[%d]%s  %s%-24s%s", j.id, mark, pid, j.describe(), cmdline)
+}
+
+// reportFinishedJobs tells the user about background jobs that finished or
+// stopped since the last prompt, and drops the finished ones from the table.
+func (sh *Interpreter) reportFinishedJobs() {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range slices.Clone(sh.jobs) {
+		state := j.state()
+		if state == JobRunning || state == j.reported {
+			continue
+		}
+		fmt.Fprintln(os.Stderr, sh.formatJob(j, false))
+		j.reported = state
+		if state == JobDone {
+			sh.removeJob(j)
+		}
+	}
+}
+
+func (sh *Interpreter) JobsCommand(argv []string, fds FdTable) int {
+	out := fds.writer(1)
+	long, pidsOnly := false, false
+	var specs []string
+	for _, arg := range argv[1:] {
+		switch arg {
+		case "-l":
+			long = true
+		case "-p":
+			pidsOnly = true
+		default:
+			specs = append(specs, arg)
+		}
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	selected := sh.jobs
+	if len(specs) > 0 {
+		selected = nil
+		for _, spec := range specs {
+			j, err := sh.findJob(spec)
+			if err != nil {
+				fmt.Fprintf(fds.writer(2), "jobs: %s\n", err)
+				return 1
+			}
+			selected = append(selected, j)
+		}
+	}
+	for _, j := range selected {
+		if pidsOnly {
+			fmt.Fprintln(out, j.pid())
+			continue
+		}
+		fmt.Fprintln(out, sh.formatJob(j, long))
+		j.reported = j.state()
+	}
+	// Like bash, listing a finished job is its notification
+	for _, j := range slices.Clone(selected) {
+		if j.state() == JobDone && !pidsOnly {
+			sh.removeJob(j)
+		}
+	}
+	return 0
+}
+
+// jobArg resolves the optional job spec of fg and bg, defaulting to %+.
+func (sh *Interpreter) jobArg(name string, argv []string, errOut io.Writer) *Job {
+	spec := "%+"
+	if len(argv) > 1 {
+		spec = argv[1]
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, err := sh.findJob(spec)
+	if err != nil {
+		if spec == "%+" {
+			err = fmt.Errorf("current: no such job")
+		}
+		fmt.Fprintf(errOut, "%s: %s\n", name, err)
+		return nil
+	}
+	return j
+}
+
+func (sh *Interpreter) FgCommand(argv []string, fds FdTable) int {
+	j := sh.jobArg("fg", argv, fds.writer(2))
+	if j == nil {
+		return 1
+	}
+	fmt.Fprintln(fds.writer(1), j.cmdline)
+	jobsMu.Lock()
+	continueJob(j)
+	jobsMu.Unlock()
+	if !sh.runForeground(j) {
+		return 128 + int(syscall.SIGTSTP)
+	}
+	return j.status
+}
+
+func (sh *Interpreter) BgCommand(argv []string, fds FdTable) int {
+	j := sh.jobArg("bg", argv, fds.writer(2))
+	if j == nil {
+		return 1
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if j.state() != JobStopped {
+		fmt.Fprintf(fds.writer(2), "bg: job %d already in background\n", j.id)
+		return 0
+	}
+	continueJob(j)
+	fmt.Fprintf(fds.writer(1), "[%d]+ %s &\n", j.id, j.cmdline)
+	return 0
+}
+
+// waitJob blocks until j finishes or stops. A finished job is removed from
+// the table and its status returned.
+func (sh *Interpreter) waitJob(j *Job) int {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for j.state() == JobRunning {
+		jobsCond.Wait()
+	}
+	if j.state() == JobStopped {
+		return 128 + int(syscall.SIGTSTP)
+	}
+	sh.removeJob(j)
+	return j.status
+}
+
+func (sh *Interpreter) WaitCommand(argv []string, fds FdTable) int {
+	if len(argv) < 2 {
+		jobsMu.Lock()
+		pending := slices.Clone(sh.jobs)
+		jobsMu.Unlock()
+		for _, j := range pending {
+			sh.waitJob(j)
+		}
+		return 0
+	}
+	status := 0
+	for _, spec := range argv[1:] {
+		jobsMu.Lock()
+		j, err := sh.findJob(spec)
+		jobsMu.Unlock()
+		if err != nil {
+			fmt.Fprintf(fds.writer(2), "wait: %s\n", err)
+			status = 127
+			continue
+		}
+		status = sh.waitJob(j)
+	}
+	return status
+}
+
+func (sh *Interpreter) DisownCommand(argv []string, fds FdTable) int {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	specs := argv[1:]
+	if len(specs) == 0 {
+		specs = []string{"%+"}
+	}
+	for _, spec := range specs {
+		if spec == "-a" {
+			sh.jobs = nil
+			return 0
+		}
+		j, err := sh.findJob(spec)
+		if err != nil {
+			if spec == "%+" {
+				err = fmt.Errorf("current: no such job")
+			}
+			fmt.Fprintf(fds.writer(2), "disown: %s\n", err)
+			return 1
+		}
+		sh.removeJob(j)
+	}
+	return 0
+}
@@ -0,0 +1,582 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+var builtIns = []string{"type", "echo", "exit", "pwd", "cd", "history", "set", "exec", "jobs", "fg", "bg", "wait", "disown", "export", "unset", "env", "hash", "return", "ulimit", "trap", "shopt", "break", "continue", "read", "source", ".", "sandbox"}
+
+type History struct {
+	Entries           []string
+	lastAppendedIndex int
+}
+
+func (h *History) Add(entry string) {
+	h.Entries = append(h.Entries, entry)
+}
+
+func (h *History) Get(index int) (string, bool) {
+	if index >= 0 && index < len(h.Entries) {
+		return h.Entries[index], true
+	}
+	return "", false
+}
+
+func (h *History) Len() int {
+	return len(h.Entries)
+}
+
+func (h *History) WriteToFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, entry := range h.Entries {
+		fmt.Fprintln(file, entry)
+	}
+	return nil
+}
+
+func (h *History) AppendToFile(filename string) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for i := h.lastAppendedIndex; i < len(h.Entries); i++ {
+		fmt.Fprintln(file, h.Entries[i])
+	}
+	h.lastAppendedIndex = len(h.Entries)
+	return nil
+}
+
+func (h *History) ReadFromFile(filename string) error {
+	file, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(file), "\n")
+	for _, line := range lines {
+		if line != "" {
+			h.Entries = append(h.Entries, line)
+		}
+	}
+	h.lastAppendedIndex = len(h.Entries)
+	return nil
+}
+
+// Main is the shell as a program. After its options, it runs the commands
+// given with -c, or the script named by the first argument, with the rest
+// as its arguments. With no arguments it reads commands from standard input,
+// interactively when that is a terminal. It returns the status to exit with.
+func Main(args []string) int {
+	trampolineReady = true
+	if len(args) > 1 && args[1] == trampolineFlag {
+		runTrampoline(args[2:])
+	}
+	start := startup{login: strings.HasPrefix(args[0], "-")}
+	restricted := shellBaseName(args[0]) == "rbash"
+	command, hasCommand := "", false
+	n := 1
+options:
+	for ; n < len(args); n++ {
+		switch arg := args[n]; arg {
+		case "-l", "--login":
+			start.login = true
+		case "-r", "--restricted":
+			restricted = true
+		case "--norc":
+			start.norc = true
+		case "--noprofile":
+			start.noprofile = true
+		case "--rcfile", "-c":
+			if n+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%s: %s: option requires an argument\n", args[0], arg)
+				return 2
+			}
+			n++
+			if arg == "-c" {
+				command, hasCommand = args[n], true
+			} else {
+				start.rcfile = args[n]
+			}
+		case "--":
+			n++
+			break options
+		default:
+			if strings.HasPrefix(arg, "--") {
+				fmt.Fprintf(os.Stderr, "%s: %s: invalid option\n", args[0], arg)
+				return 2
+			}
+			break options
+		}
+	}
+	operands := args[n:]
+
+	i := New()
+	i.standalone = true
+	pinFileLimit()
+	i.name = args[0]
+	ctx := context.Background()
+	name := shellBaseName(args[0])
+	interactive := !hasCommand && len(operands) == 0 && term.IsTerminal(int(os.Stdin.Fd()))
+	if !interactive && start.login {
+		if status, _ := i.run(ctx, func() { start.load(i, name, false) }); i.exiting {
+			return status
+		}
+	}
+	if !interactive && restricted {
+		i.restrict()
+	}
+	switch {
+	case hasCommand:
+		// As with sh -c, the word after the commands is $0
+		if len(operands) > 0 {
+			i.name, i.args = operands[0], operands[1:]
+		}
+		status, _ := i.Run(ctx, command)
+		i.Close()
+		return status
+	case len(operands) > 0:
+		status, err := i.RunFile(ctx, operands[0], operands[1:]...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+		}
+		i.Close()
+		return status
+	case !interactive:
+		// Piped or redirected input is a script too, not a session
+		status, _ := i.run(ctx, func() { i.runInput(os.Stdin) })
+		i.Close()
+		return status
+	}
+
+	// The terminal session belongs to this interpreter for good
+	sh := i
+	sh.ctx = context.Background()
+	sh.interactive = true
+	initJobControl()
+	start.load(sh, name, true)
+	if sh.exiting {
+		sh.leave(sh.exit())
+	}
+	if restricted {
+		sh.restrict()
+	}
+	sh.histFile = sh.getVar("HISTFILE")
+	argv := "history -r " + sh.histFile
+	HistoryCommand(strings.Split(argv, " "), os.Stdin, os.Stdout, sh.hist)
+	sh.hist.lastAppendedIndex = sh.hist.Len()
+	sh.trie = NewTrie()
+
+	for i := 0; i < len(builtIns); i++ {
+		sh.trie.insert(builtIns[i])
+	}
+
+	for _, exe := range sh.getPathExecutables() {
+		sh.trie.insert(exe)
+	}
+
+	for name := range sh.functions {
+		sh.trie.insert(name)
+	}
+
+	for {
+		// fmt.Fprint(os.Stdout, "$ ")
+		sh.historyIndex = sh.hist.Len()
+		input := sh.handleInput("$ ")
+		if len(input) == 0 {
+			sh.historyIndex = sh.hist.Len() // Reset historyIndex after each input
+			continue
+		}
+		text := input
+		for needsMore(text) {
+			line := sh.handleInput("> ")
+			text += "\n" + line
+			input = joinLines(input, line)
+		}
+		sh.hist.Add(input)
+		sh.historyIndex = sh.hist.Len()
+		sh.interrupted = false
+		sh.executeLine(text)
+		sh.runPendingTraps()
+		if sh.exiting {
+			sh.leave(sh.exit())
+		}
+	}
+}
+
+// leave ends an interactive shell after saving its history.
+func (sh *Interpreter) leave(status int) {
+	sh.saveHistory()
+	os.Exit(status)
+}
+
+// saveHistory writes the history to HISTFILE.
+func (sh *Interpreter) saveHistory() {
+	if sh.histFile == "" {
+		return
+	}
+	sh.hist.WriteToFile(sh.histFile)
+}
+
+// runSource runs a script and returns the status of the last command.
+func (sh *Interpreter) runSource(src string) int {
+	lines := strings.Split(src, "\n")
+	return sh.runLines(func() (string, bool) {
+		if len(lines) == 0 {
+			return "", false
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, true
+	})
+}
+
+// runInput runs the commands read from in as they arrive. Lines are read a
+// byte at a time, so a command that reads the same input gets the lines
+// after its own.
+func (sh *Interpreter) runInput(in io.Reader) int {
+	return sh.runLines(func() (string, bool) {
+		line, err := readLine(in, true)
+		return line, err == nil || line != ""
+	})
+}
+
+// runLines runs the lines next returns one at a time, except that a command
+// spanning several lines is read whole, and returns the status of the last
+// command.
+func (sh *Interpreter) runLines(next func() (string, bool)) int {
+	lineNo := 0
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		lineNo++
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sh.lineNo = lineNo
+		for needsMore(line) {
+			more, ok := next()
+			if !ok {
+				break
+			}
+			lineNo++
+			line += "\n" + more
+		}
+		sh.executeLine(line)
+		sh.runPendingTraps()
+		if sh.unwinding() {
+			break
+		}
+	}
+	return sh.lastStatus
+}
+
+// executeLine runs one line of input and sets $? from it.
+func (sh *Interpreter) executeLine(input string) {
+	list, err := parse(input)
+	if err != nil {
+		fmt.Fprintf(sh.fds.writer(2), "%s%s\n", sh.location(), err)
+		sh.lastStatus = 2
+		return
+	}
+	sh.runList(list)
+}
+
+// expandDollar expands the parameter reference that follows a '$' and
+// returns its value and how many runes of rest it used.
+func (sh *Interpreter) expandDollar(rest []rune) (string, int) {
+	if len(rest) == 0 {
+		return "$", 0
+	}
+	switch c := rest[0]; {
+	case c == '{':
+		for j := 1; j < len(rest); j++ {
+			if rest[j] == '}' {
+				return sh.lookupVar(string(rest[1:j])), j + 1
+			}
+		}
+		return "$", 0
+	case c == '?' || c == '$' || c == '!' || c == '#' || c == '@' || c == '*' || c == '-' || unicode.IsDigit(c):
+		return sh.lookupVar(string(c)), 1
+	case c == '_' || unicode.IsLetter(c):
+		j := 1
+		for j < len(rest) && (rest[j] == '_' || unicode.IsLetter(rest[j]) || unicode.IsDigit(rest[j])) {
+			j++
+		}
+		return sh.lookupVar(string(rest[:j])), j
+	}
+	return "$", 0
+}
+
+// lookupArray returns the elements of an array variable.
+func (sh *Interpreter) lookupArray(name string) ([]string, bool) {
+	switch name {
+	case "PIPESTATUS":
+		elems := make([]string, len(sh.pipeStatus))
+		for i, status := range sh.pipeStatus {
+			elems[i] = strconv.Itoa(status)
+		}
+		return elems, true
+	}
+	elems, ok := sh.arrays[name]
+	return elems, ok
+}
+
+func (sh *Interpreter) lookupVar(name string) string {
+	// NAME[i], NAME[@] and plain NAME, which is element 0 of an array
+	base, index, subscripted := strings.Cut(strings.TrimSuffix(name, "]"), "[")
+	if elems, ok := sh.lookupArray(base); ok {
+		if !subscripted {
+			index = "0"
+		}
+		if index == "@" || index == "*" {
+			return strings.Join(elems, " ")
+		}
+		if i, err := strconv.Atoi(index); err == nil && i >= 0 && i < len(elems) {
+			return elems[i]
+		}
+		return ""
+	}
+	switch name {
+	case "?":
+		return strconv.Itoa(sh.lastStatus)
+	case "$":
+		return strconv.Itoa(os.Getpid())
+	case "!":
+		if sh.lastBgPid == 0 {
+			return ""
+		}
+		return strconv.Itoa(sh.lastBgPid)
+	case "#":
+		return strconv.Itoa(len(sh.args))
+	case "@", "*":
+		return strings.Join(sh.args, " ")
+	case "0":
+		return sh.name
+	case "-":
+		return sh.optionFlags()
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		if n > len(sh.args) {
+			if sh.optionOn("nounset") && sh.unboundVar == "" {
+				sh.unboundVar = name
+			}
+			return ""
+		}
+		return sh.args[n-1]
+	}
+	value, ok := sh.lookupShellVar(name)
+	if !ok && sh.optionOn("nounset") && sh.unboundVar == "" {
+		sh.unboundVar = name
+	}
+	return value
+}
+
+// Menu runs a builtin or external command and returns its exit status.
+func (sh *Interpreter) Menu(cmd string, argv []string, fds FdTable) int {
+	if fn, ok := sh.functions[cmd]; ok {
+		return sh.callFunction(fn, argv, fds)
+	}
+	if isBuiltin(cmd) {
+		return sh.callBuiltin(argv, fds)
+	}
+	return sh.runExternal(argv, sh.exportedEnv(), fds)
+}
+
+// runExternal runs argv as a foreground job with the environment env. When
+// the terminal belongs to some other job, as it does for a pipeline stage,
+// the command is simply waited for.
+func (sh *Interpreter) runExternal(argv []string, env []string, fds FdTable) int {
+	cmd := argv[0]
+	filePath, status := sh.lookupCommand(cmd)
+	if status == 127 && !strings.Contains(cmd, "/") {
+		return sh.commandNotFound(argv, env, fds)
+	}
+	if status != 0 {
+		sh.reportLookupFailure(fds.writer(2), cmd, status)
+		return status
+	}
+	return sh.runProgram(filePath, argv, append(env, "_="+filePath), fds)
+}
+
+// runProgram is runExternal for a program that has already been looked up.
+func (sh *Interpreter) runProgram(filePath string, argv []string, env []string, fds FdTable) int {
+	cmd := argv[0]
+	command := exec.Command(filePath, argv[1:]...)
+	command.Args[0] = cmd
+	command.Env = env
+	command.Dir = sh.dir
+	fds.attach(command, sh.coprocFd)
+	p := sh.newPipeline(1, ownsTerminal())
+	err := p.startExternal(0, command, func(res stageResult) { p.results <- res })
+	if err != nil {
+		fmt.Fprintf(fds.writer(2), "%s: %s\n", cmd, err)
+		return exitStatus(err)
+	}
+	j := sh.newJob(strings.Join(argv, " "), p)
+	if !p.foreground {
+		return sh.waitJob(j)
+	}
+	if !sh.runForeground(j) {
+		return 128 + int(syscall.SIGTSTP)
+	}
+	return j.status
+}
+
+func (sh *Interpreter) moveUpDownHistory(direction int) string {
+	if sh.hist.Len() == 0 {
+		fmt.Fprintln(os.Stdout, "No commands in history.")
+		return ""
+	}
+	cur_cnt := sh.hist.Len() + 1
+
+	if direction == 0 { // Move up
+		if cur_cnt > 1 {
+			cur_cnt--
+		}
+	} else if direction == 1 { // Move down
+		if cur_cnt < len(sh.hist.Entries) {
+			cur_cnt++
+		}
+	}
+
+	if command, exists := sh.hist.Get(cur_cnt - 1); exists {
+		fmt.Fprintf(os.Stdout, "%s", command)
+		return command
+	} else {
+		fmt.Fprintln(os.Stdout, "No more commands in history.")
+	}
+	return ""
+}
+
+func (sh *Interpreter) getPathExecutables() []string {
+	pathEnv := sh.getVar("PATH")
+	paths := strings.Split(pathEnv, ":")
+	seen := make(map[string]struct{})
+	var executables []string
+
+	for _, dir := range paths {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.Type().IsRegular() || file.Type()&os.ModeSymlink != 0 {
+				name := file.Name()
+				if _, ok := seen[name]; !ok {
+					seen[name] = struct{}{}
+					executables = append(executables, name)
+				}
+			}
+		}
+	}
+	return executables
+}
+
+// A word is one word of a command line after quote removal and expansion.
+// typed marks the bytes of text that were typed as they are, outside quotes
+// and escapes and not from an expansion. Only those can make a redirection
+// operator, so "2>&1" and $x stay arguments.
+type word struct {
+	text  string
+	typed []bool
+}
+
+// typedWord is a word that was typed as it is.
+func typedWord(text string) word {
+	typed := make([]bool, len(text))
+	for i := range typed {
+		typed[i] = true
+	}
+	return word{text, typed}
+}
+
+func wordTexts(words []word) []string {
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.text
+	}
+	return texts
+}
+
+// splitWords splits a command line into words, removing quotes and
+// expanding $ references.
+func (sh *Interpreter) splitWords(inputString string) []word {
+	var current strings.Builder
+	args := []word{}
+	inSingleQuote := false
+	inDoubleQuote := false
+	escaped := false
+	var typed []bool
+	// write adds to the current word, marking whether s was typed as it is
+	write := func(s string, plain bool) {
+		current.WriteString(s)
+		for range len(s) {
+			typed = append(typed, plain)
+		}
+	}
+	finish := func() {
+		args = append(args, word{current.String(), typed})
+		current.Reset()
+		typed = nil
+	}
+
+	runes := []rune(inputString)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case escaped:
+			if inDoubleQuote {
+				switch c {
+				case '"', '\\', '$', '`':
+					write(string(c), false)
+				default:
+					write("\\"+string(c), false)
+				}
+			} else {
+				write(string(c), false)
+			}
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case c == '$' && !inSingleQuote:
+			value, n := sh.expandDollar(runes[i+1:])
+			write(value, false)
+			i += n
+		case unicode.IsSpace(c) && !inSingleQuote && !inDoubleQuote:
+			if current.Len() > 0 {
+				finish()
+			}
+		default:
+			write(string(c), !inSingleQuote && !inDoubleQuote)
+		}
+	}
+
+	if escaped {
+		write("\\", false)
+	}
+	if current.Len() > 0 {
+		finish()
+	}
+	return args
+}
@@ -0,0 +1,252 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// shellOption is one entry in the options registry. set -o and the
+// single-letter set flags change the plain options, shopt the rest.
+type shellOption struct {
+	name   string
+	letter byte // its set flag, if it has one
+	shopt  bool
+	on     bool
+}
+
+// newOptions returns the options with their defaults, for a new interpreter.
+func newOptions() []*shellOption {
+	return []*shellOption{
+		{name: "errexit", letter: 'e'},
+		{name: "nounset", letter: 'u'},
+		{name: "xtrace", letter: 'x'},
+		{name: "noclobber", letter: 'C'},
+		{name: "pipefail"},
+		{name: "multios"},
+		{name: "posix"},
+		{name: "correct"},
+		{name: "restricted", letter: 'r'},
+		{name: "sandbox"},
+		{name: "sourcepath", shopt: true, on: true},
+	}
+}
+
+func (sh *Interpreter) findOption(name string, shopt bool) *shellOption {
+	for _, o := range sh.options {
+		if o.name == name && o.shopt == shopt {
+			return o
+		}
+	}
+	return nil
+}
+
+func (sh *Interpreter) letterOption(letter byte) *shellOption {
+	for _, o := range sh.options {
+		if o.letter == letter {
+			return o
+		}
+	}
+	return nil
+}
+
+// setOption turns o on or off. Only a shell that isn't restricted can turn
+// off restricted.
+func setOption(o *shellOption, on bool) error {
+	if o.name == "restricted" && o.on && !on {
+		return errors.New("cannot be turned off in a restricted shell")
+	}
+	o.on = on
+	return nil
+}
+
+func (sh *Interpreter) optionOn(name string) bool {
+	for _, o := range sh.options {
+		if o.name == name {
+			return o.on
+		}
+	}
+	return false
+}
+
+// optionFlags is $-: the letters of the set flags that are on.
+func (sh *Interpreter) optionFlags() string {
+	var flags strings.Builder
+	for _, o := range sh.options {
+		if o.on && o.letter != 0 {
+			flags.WriteByte(o.letter)
+		}
+	}
+	if sh.interactive {
+		flags.WriteByte('i')
+	}
+	return flags.String()
+}
+
+func (sh *Interpreter) SetCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	if len(argv) == 1 {
+		sh.printVars(out)
+		return 0
+	}
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" || arg == "-" {
+			// Everything after it becomes $1, $2, ...
+			sh.args = slices.Clone(argv[i+1:])
+			return 0
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			sh.args = slices.Clone(argv[i:])
+			return 0
+		}
+		on := arg[0] == '-'
+		for j := 1; j < len(arg); j++ {
+			if arg[j] == 'o' {
+				if i+1 >= len(argv) {
+					sh.printOptions(out, on, false)
+					continue
+				}
+				i++
+				o := sh.findOption(argv[i], false)
+				if o == nil {
+					fmt.Fprintf(errOut, "set: %s: invalid option name\n", argv[i])
+					return 1
+				}
+				if err := setOption(o, on); err != nil {
+					fmt.Fprintf(errOut, "set: %s: %s\n", o.name, err)
+					return 1
+				}
+				continue
+			}
+			o := sh.letterOption(arg[j])
+			if o == nil {
+				fmt.Fprintf(errOut, "set: %c%c: invalid option\n", arg[0], arg[j])
+				return 2
+			}
+			if err := setOption(o, on); err != nil {
+				fmt.Fprintf(errOut, "set: %c%c: %s\n", arg[0], arg[j], err)
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// printOptions lists the set -o or the shopt options, the way set -o does,
+// or as commands that recreate the current state.
+func (sh *Interpreter) printOptions(out io.Writer, human bool, shopt bool) {
+	var names []string
+	for _, o := range sh.options {
+		if o.shopt == shopt {
+			names = append(names, o.name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		printOption(out, sh.findOption(name, shopt), human)
+	}
+}
+
+func printOption(out io.Writer, o *shellOption, human bool) {
+	if human {
+		state := "off"
+		if o.on {
+			state = "on"
+		}
+		fmt.Fprintf(out, "%-15s\t%s\n", o.name, state)
+		return
+	}
+	switch {
+	case o.shopt && o.on:
+		fmt.Fprintf(out, "shopt -s %s\n", o.name)
+	case o.shopt:
+		fmt.Fprintf(out, "shopt -u %s\n", o.name)
+	case o.on:
+		fmt.Fprintf(out, "set -o %s\n", o.name)
+	default:
+		fmt.Fprintf(out, "set +o %s\n", o.name)
+	}
+}
+
+// printVars lists the shell variables, the way set with no arguments does.
+func (sh *Interpreter) printVars(out io.Writer) {
+	var names []string
+	for name, v := range sh.vars {
+		if v.set {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s=%s\n", name, quoteWord(sh.vars[name].value))
+	}
+}
+
+func (sh *Interpreter) ShoptCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	var set, unset, print, quiet, setOptions bool
+	i := 1
+	for ; i < len(argv) && strings.HasPrefix(argv[i], "-"); i++ {
+		for _, c := range argv[i][1:] {
+			switch c {
+			case 's':
+				set = true
+			case 'u':
+				unset = true
+			case 'p':
+				print = true
+			case 'q':
+				quiet = true
+			case 'o':
+				setOptions = true
+			default:
+				fmt.Fprintf(errOut, "shopt: -%c: invalid option\n", c)
+				return 2
+			}
+		}
+	}
+	if set && unset {
+		fmt.Fprintln(errOut, "shopt: cannot set and unset shell options simultaneously")
+		return 1
+	}
+	names := argv[i:]
+	if len(names) == 0 {
+		for _, o := range sh.options {
+			// With -s or -u, only the options that are on, or off
+			if o.shopt == setOptions || ((set || unset) && o.on != set) {
+				continue
+			}
+			if !quiet {
+				printOption(out, o, !print)
+			}
+		}
+		return 0
+	}
+	status := 0
+	for _, name := range names {
+		o := sh.findOption(name, !setOptions)
+		if o == nil {
+			fmt.Fprintf(errOut, "shopt: %s: invalid shell option name\n", name)
+			status = 1
+			continue
+		}
+		switch {
+		case set || unset:
+			if err := setOption(o, set); err != nil {
+				fmt.Fprintf(errOut, "shopt: %s: %s\n", o.name, err)
+				status = 1
+			}
+		default:
+			if !quiet {
+				printOption(out, o, !print)
+			}
+			if !o.on {
+				status = 1
+			}
+		}
+	}
+	return status
+}
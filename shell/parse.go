@@ -0,0 +1,461 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// A parsed command line is a list of and-or lists. Pipelines are kept as the
+// text they were typed as, so that they are expanded only when they run.
+type node interface{}
+
+type listNode struct {
+	items []listItem
+}
+
+type listItem struct {
+	cmd        node
+	background bool   // ended by &
+	text       string // as typed, for jobs
+}
+
+type pipelineNode struct {
+	text string
+}
+
+// andOrNode is parts joined by ops, where ops[i] is the && or || between
+// parts[i] and parts[i+1].
+type andOrNode struct {
+	parts []node
+	ops   []string
+}
+
+type notNode struct {
+	cmd node
+}
+
+// ifNode runs bodies[i] for the first conds[i] that succeeds, and elseBody
+// when none do.
+type ifNode struct {
+	conds    []*listNode
+	bodies   []*listNode
+	elseBody *listNode
+}
+
+type loopNode struct {
+	until bool
+	cond  *listNode
+	body  *listNode
+}
+
+type groupNode struct {
+	body *listNode
+}
+
+// coprocNode runs a pipeline in the background with pipes to and from it.
+type coprocNode struct {
+	name string
+	cmd  *pipelineNode
+	text string
+}
+
+type funcDefNode struct {
+	name string
+	body *listNode
+	text string
+}
+
+// errIncomplete means the input ended in the middle of a command, so more
+// lines are needed.
+var errIncomplete = errors.New("syntax error: unexpected end of file")
+
+// Words that end a list when they start a command
+var closingWords = []string{"then", "elif", "else", "fi", "do", "done", "}"}
+
+type token struct {
+	op    string // "", or one of ; & && ||
+	word  string // the word as typed, quotes and all
+	start int
+	end   int
+}
+
+// tokenize splits src into words and the operators that separate commands.
+// Pipes and redirections stay inside the words, for the pipeline code.
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	runes := []rune(src)
+	// Byte offsets, so tokens can be sliced out of src
+	offsets := make([]int, len(runes)+1)
+	for i, pos := 0, 0; i < len(runes); i++ {
+		offsets[i] = pos
+		pos += len(string(runes[i]))
+	}
+	offsets[len(runes)] = len(src)
+
+	wordStart := -1
+	inSingle, inDouble, escaped := false, false, false
+	endWord := func(i int) {
+		if wordStart >= 0 {
+			toks = append(toks, token{word: src[offsets[wordStart]:offsets[i]], start: offsets[wordStart], end: offsets[i]})
+			wordStart = -1
+		}
+	}
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if escaped || inSingle || inDouble {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && inDouble:
+				escaped = true
+			case c == '\'' && inSingle:
+				inSingle = false
+			case c == '"' && inDouble:
+				inDouble = false
+			}
+			continue
+		}
+		var prev, next rune
+		if i > 0 {
+			prev = runes[i-1]
+		}
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		op := ""
+		switch {
+		case c == '\n' || c == ';':
+			op = ";"
+		case c == '&' && next == '&':
+			op = "&&"
+		case c == '|' && next == '|':
+			op = "||"
+		case c == '&' && next != '>' && prev != '>' && prev != '<' && prev != '|':
+			op = "&"
+		case c == '#' && wordStart < 0:
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+			continue
+		case unicode.IsSpace(c):
+			endWord(i)
+			continue
+		}
+		if op != "" {
+			endWord(i)
+			toks = append(toks, token{op: op, start: offsets[i], end: offsets[i+len(op)]})
+			i += len(op) - 1
+			continue
+		}
+		if wordStart < 0 {
+			wordStart = i
+		}
+		switch c {
+		case '\\':
+			escaped = true
+		case '\'':
+			inSingle = true
+		case '"':
+			inDouble = true
+		}
+	}
+	if inSingle || inDouble || escaped {
+		return nil, errIncomplete
+	}
+	endWord(len(runes))
+	return toks, nil
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+// parse turns a command line into a list. errIncomplete means src stops
+// in the middle of a command.
+func parse(src string) (*listNode, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks}
+	list, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, p.unexpected()
+	}
+	return list, nil
+}
+
+// needsMore reports whether src stops in the middle of a command, so that
+// the next line belongs to it too.
+func needsMore(src string) bool {
+	_, err := parse(src)
+	return errors.Is(err, errIncomplete)
+}
+
+// joinLines puts a command that took several lines on one line, for the history.
+func joinLines(text, line string) string {
+	trimmed := strings.TrimSpace(text)
+	for _, open := range []string{"{", "then", "do", "else", "&&", "||", "|"} {
+		if strings.HasSuffix(trimmed, open) {
+			return text + " " + line
+		}
+	}
+	return text + "; " + line
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.toks)
+}
+
+// peekWord returns the next token if it is a word, or "".
+func (p *parser) peekWord() string {
+	if p.eof() {
+		return ""
+	}
+	return p.toks[p.pos].word
+}
+
+func (p *parser) peekOp(op string) bool {
+	return !p.eof() && p.toks[p.pos].op == op
+}
+
+func (p *parser) skipSeparators() {
+	for p.peekOp(";") {
+		p.pos++
+	}
+}
+
+func (p *parser) unexpected() error {
+	if p.eof() {
+		return errIncomplete
+	}
+	t := p.toks[p.pos]
+	return fmt.Errorf("syntax error near unexpected token `%s%s'", t.op, t.word)
+}
+
+func (p *parser) expect(word string) error {
+	if p.peekWord() != word {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
+
+// parseList reads commands until the input ends or, when stop words are
+// given, until one of them starts a command.
+func (p *parser) parseList(stop ...string) (*listNode, error) {
+	list := &listNode{}
+	for {
+		p.skipSeparators()
+		if p.eof() {
+			if len(stop) > 0 {
+				return nil, errIncomplete
+			}
+			return list, nil
+		}
+		if w := p.peekWord(); slices.Contains(closingWords, w) {
+			if slices.Contains(stop, w) {
+				return list, nil
+			}
+			return nil, p.unexpected()
+		}
+		start := p.toks[p.pos].start
+		cmd, err := p.parseAndOr()
+		if err != nil {
+			return nil, err
+		}
+		item := listItem{cmd: cmd, text: p.src[start:p.toks[p.pos-1].end]}
+		switch {
+		case p.peekOp("&"):
+			item.background = true
+			p.pos++
+		case p.peekOp(";"):
+			p.pos++
+		case !p.eof() && !slices.Contains(stop, p.peekWord()):
+			return nil, p.unexpected()
+		}
+		list.items = append(list.items, item)
+	}
+}
+
+func (p *parser) parseAndOr() (node, error) {
+	first, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	and := &andOrNode{parts: []node{first}}
+	for p.peekOp("&&") || p.peekOp("||") {
+		and.ops = append(and.ops, p.toks[p.pos].op)
+		p.pos++
+		p.skipSeparators() // a newline may follow && and ||
+		next, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		and.parts = append(and.parts, next)
+	}
+	if len(and.ops) == 0 {
+		return first, nil
+	}
+	return and, nil
+}
+
+func (p *parser) parsePipeline() (node, error) {
+	if p.peekWord() == "!" {
+		p.pos++
+		cmd, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{cmd}, nil
+	}
+	return p.parseCommand()
+}
+
+var funcNameRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\(\)(\{?)$`)
+
+func (p *parser) parseCommand() (node, error) {
+	if p.eof() {
+		return nil, errIncomplete
+	}
+	if p.toks[p.pos].op != "" {
+		return nil, p.unexpected()
+	}
+	switch w := p.peekWord(); {
+	case w == "if":
+		return p.parseIf()
+	case w == "while" || w == "until":
+		p.pos++
+		cond, err := p.parseList("do")
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		body, err := p.parseList("done")
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		return &loopNode{until: w == "until", cond: cond, body: body}, nil
+	case w == "coproc":
+		return p.parseCoproc()
+	case w == "{":
+		p.pos++
+		body, err := p.parseList("}")
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		return &groupNode{body}, nil
+	case w == "function" || funcNameRe.MatchString(w) ||
+		(nameRe.MatchString(w) && p.pos+1 < len(p.toks) && p.toks[p.pos+1].word == "()"):
+		return p.parseFuncDef()
+	}
+	// A pipeline runs up to the next operator
+	start := p.pos
+	for !p.eof() && p.toks[p.pos].op == "" {
+		p.pos++
+	}
+	return &pipelineNode{p.src[p.toks[start].start:p.toks[p.pos-1].end]}, nil
+}
+
+func (p *parser) parseIf() (node, error) {
+	n := &ifNode{}
+	for w := "if"; w == "if" || w == "elif"; w = p.peekWord() {
+		p.pos++
+		cond, err := p.parseList("then")
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		body, err := p.parseList("elif", "else", "fi")
+		if err != nil {
+			return nil, err
+		}
+		n.conds = append(n.conds, cond)
+		n.bodies = append(n.bodies, body)
+	}
+	if p.peekWord() == "else" {
+		p.pos++
+		body, err := p.parseList("fi")
+		if err != nil {
+			return nil, err
+		}
+		n.elseBody = body
+	}
+	if err := p.expect("fi"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// parseCoproc reads coproc [NAME] command. As in bash, a NAME is only taken
+// before a { } group, which must hold a single pipeline.
+func (p *parser) parseCoproc() (node, error) {
+	start := p.toks[p.pos].start
+	p.pos++
+	name := "COPROC"
+	if w := p.peekWord(); nameRe.MatchString(w) && p.pos+1 < len(p.toks) && p.toks[p.pos+1].word == "{" {
+		name = w
+		p.pos++
+	}
+	cmd, err := p.parseCommand()
+	if err != nil {
+		return nil, err
+	}
+	if group, ok := cmd.(*groupNode); ok && len(group.body.items) == 1 && !group.body.items[0].background {
+		cmd = group.body.items[0].cmd
+	}
+	pl, ok := cmd.(*pipelineNode)
+	if !ok {
+		return nil, fmt.Errorf("coproc: only a single pipeline can run as a coprocess")
+	}
+	return &coprocNode{name: name, cmd: pl, text: p.src[start:p.toks[p.pos-1].end]}, nil
+}
+
+// parseFuncDef reads name() { ...; }, with or without the function keyword.
+func (p *parser) parseFuncDef() (node, error) {
+	start := p.toks[p.pos].start
+	w := p.peekWord()
+	if w == "function" {
+		p.pos++
+		if w = p.peekWord(); w == "" {
+			return nil, p.unexpected()
+		}
+	}
+	var name string
+	opened := false
+	if m := funcNameRe.FindStringSubmatch(w); m != nil {
+		name, opened = m[1], m[2] != ""
+		p.pos++
+	} else if nameRe.MatchString(w) {
+		name = w
+		p.pos++
+		if p.peekWord() == "()" {
+			p.pos++
+		}
+	} else {
+		return nil, p.unexpected()
+	}
+	if !opened {
+		p.skipSeparators()
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+	}
+	body, err := p.parseList("}")
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+	return &funcDefNode{name: name, body: body, text: p.src[start:p.toks[p.pos-1].end]}, nil
+}
@@ -0,0 +1,235 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string // words as typed, and operators in brackets
+		err  error
+	}{
+		{src: "echo a b", want: []string{"echo", "a", "b"}},
+		{src: "a;b\nc", want: []string{"a", "[;]", "b", "[;]", "c"}},
+		{src: "a&&b||c", want: []string{"a", "[&&]", "b", "[||]", "c"}},
+		{src: "sleep 1&", want: []string{"sleep", "1", "[&]"}},
+
+		// & next to a redirection is part of it
+		{src: "echo a >&2 &", want: []string{"echo", "a", ">&2", "[&]"}},
+		{src: "cat <&3", want: []string{"cat", "<&3"}},
+		{src: "ls &>out", want: []string{"ls", "&>out"}},
+		{src: "ls &>>out &", want: []string{"ls", "&>>out", "[&]"}},
+		{src: "ls 2>&1 >f", want: []string{"ls", "2>&1", ">f"}},
+
+		// Pipes stay in the words, for the pipeline code
+		{src: "ls |& cat", want: []string{"ls", "|&", "cat"}},
+		{src: "ls|&cat", want: []string{"ls|&cat"}},
+		{src: "a | b", want: []string{"a", "|", "b"}},
+
+		// # starts a comment only at the start of a word
+		{src: "echo a#b # c; d", want: []string{"echo", "a#b"}},
+		{src: "# all\necho x", want: []string{"[;]", "echo", "x"}},
+		{src: "echo '#' \"#\" \\#", want: []string{"echo", "'#'", `"#"`, `\#`}},
+
+		// Quotes and escapes keep operators in the word
+		{src: `echo "a;b" 'c&d' e\;f`, want: []string{"echo", `"a;b"`, "'c&d'", `e\;f`}},
+		{src: `echo "a \" b" 'x'"y"`, want: []string{"echo", `"a \" b"`, `'x'"y"`}},
+		{src: "echo 'a\nb'", want: []string{"echo", "'a\nb'"}},
+
+		{src: "echo 'abc", err: errIncomplete},
+		{src: `echo "abc`, err: errIncomplete},
+		{src: `echo "a\"`, err: errIncomplete},
+		{src: `echo abc\`, err: errIncomplete},
+	}
+	for _, tt := range tests {
+		toks, err := tokenize(tt.src)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("tokenize(%q) error = %v, want %v", tt.src, err, tt.err)
+			continue
+		}
+		var got []string
+		for _, tok := range toks {
+			if tok.op != "" {
+				got = append(got, "["+tok.op+"]")
+			} else {
+				got = append(got, tok.word)
+			}
+			if want := tt.src[tok.start:tok.end]; tok.op == "" && tok.word != want {
+				t.Errorf("tokenize(%q): word %q is %q in the source", tt.src, tok.word, want)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestSplitPipeline(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{src: "a | b|c", want: []string{"a ", " b", "c"}},
+		{src: "a |& b", want: []string{"a ", "& b"}},
+		{src: "a >| f | b", want: []string{"a >| f ", " b"}},
+		{src: `echo 'a|b' "c|d" | e`, want: []string{`echo 'a|b' "c|d" `, " e"}},
+		{src: `echo a\|b`, want: []string{`echo a\|b`}},
+		{src: `echo "a\"|b" | c`, want: []string{`echo "a\"|b" `, " c"}},
+		{src: `echo '\'|b`, want: []string{`echo '\'`, "b"}},
+		{src: `echo \>|b`, want: []string{`echo \>`, "b"}},
+	}
+	for _, tt := range tests {
+		if got := splitPipelineWithQuoting(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("splitPipelineWithQuoting(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		err  string // the error's text, when parse fails
+	}{
+		{src: "", want: "{}"},
+		{src: "echo a", want: `{"echo a"}`},
+		{src: "a; b & c", want: `{"a"; "b" &; "c"}`},
+		{src: "a\n\nb;", want: `{"a"; "b"}`},
+		{src: "a | b |& c > f", want: `{"a | b |& c > f"}`},
+		{src: "a 2>&1 & b", want: `{"a 2>&1" &; "b"}`},
+		{src: "a &> f &", want: `{"a &> f" &}`},
+		{src: "a && b || ! c", want: `{("a" && "b" || !"c")}`},
+		{src: "a &&\nb", want: `{("a" && "b")}`},
+		{src: "a # b && c\nd", want: `{"a"; "d"}`},
+
+		{src: "if a; then b; fi", want: `{if({"a"} {"b"})}`},
+		{src: "if a; then b; elif c; then d; else e; fi", want: `{if({"a"} {"b"} {"c"} {"d"} else {"e"})}`},
+		{src: "if a\nthen\n  b\nfi", want: `{if({"a"} {"b"})}`},
+		{src: "while a; do b; c; done", want: `{while({"a"} {"b"; "c"})}`},
+		{src: "until a; do b; done &", want: `{until({"a"} {"b"}) &}`},
+		{
+			src:  "if a; then while b; do if c; then d; fi; done; else until e; do f; done; fi",
+			want: `{if({"a"} {while({"b"} {if({"c"} {"d"})})} else {until({"e"} {"f"})})}`,
+		},
+		{src: "{ a; b; } && c", want: `{({"a"; "b"} && "c")}`},
+
+		{src: "f() { a; b; }", want: `{f(){"a"; "b"}}`},
+		{src: "f(){ a; }", want: `{f(){"a"}}`},
+		{src: "f () { a; }", want: `{f(){"a"}}`},
+		{src: "function f { a; }", want: `{f(){"a"}}`},
+		{src: "function f() {\n a\n}", want: `{f(){"a"}}`},
+		{src: "f() {\n if a; then b; fi\n}; f", want: `{f(){if({"a"} {"b"})}; "f"}`},
+
+		{src: "coproc cat", want: `{coproc COPROC("cat")}`},
+		{src: "coproc C { cat | tr a b; }", want: `{coproc C("cat | tr a b")}`},
+
+		{src: "if a; then b", err: errIncomplete.Error()},
+		{src: "if a; then b; else", err: errIncomplete.Error()},
+		{src: "while a; do", err: errIncomplete.Error()},
+		{src: "f() {", err: errIncomplete.Error()},
+		{src: "{ a;", err: errIncomplete.Error()},
+		{src: "a &&", err: errIncomplete.Error()},
+		{src: "a ||\n", err: errIncomplete.Error()},
+		{src: "echo 'a", err: errIncomplete.Error()},
+		{src: "if a; then echo \"b", err: errIncomplete.Error()},
+
+		{src: "fi", err: "syntax error near unexpected token `fi'"},
+		{src: "a; done", err: "syntax error near unexpected token `done'"},
+		{src: "if a; then b; fi fi", err: "syntax error near unexpected token `fi'"},
+		{src: "while a; then b; done", err: "syntax error near unexpected token `then'"},
+		{src: "& a", err: "syntax error near unexpected token `&'"},
+		{src: "a && && b", err: "syntax error near unexpected token `&&'"},
+		{src: "coproc { a; b; }", err: "coproc: only a single pipeline can run as a coprocess"},
+	}
+	for _, tt := range tests {
+		list, err := parse(tt.src)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parse(%q) error = %v, want %s", tt.src, err, tt.err)
+			}
+			if got := needsMore(tt.src); got != (tt.err == errIncomplete.Error()) {
+				t.Errorf("needsMore(%q) = %v", tt.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse(%q) error = %v", tt.src, err)
+			continue
+		}
+		if got := showNode(list); got != tt.want {
+			t.Errorf("parse(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	// The text kept for jobs and type is the command as typed
+	list, err := parse("sleep 1 &  f() { a;  b; } # done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list.items[0].text; got != "sleep 1" {
+		t.Errorf("job text = %q, want %q", got, "sleep 1")
+	}
+	fn, ok := list.items[1].cmd.(*funcDefNode)
+	if !ok {
+		t.Fatalf("second item is %T, want a function definition", list.items[1].cmd)
+	}
+	if want := "f() { a;  b; }"; fn.text != want {
+		t.Errorf("function text = %q, want %q", fn.text, want)
+	}
+}
+
+// showNode renders a parsed command compactly, for comparing with what a
+// test expects.
+func showNode(n node) string {
+	switch n := n.(type) {
+	case *listNode:
+		var items []string
+		for _, item := range n.items {
+			s := showNode(item.cmd)
+			if item.background {
+				s += " &"
+			}
+			items = append(items, s)
+		}
+		return "{" + strings.Join(items, "; ") + "}"
+	case *pipelineNode:
+		return fmt.Sprintf("%q", n.text)
+	case *andOrNode:
+		s := showNode(n.parts[0])
+		for i, op := range n.ops {
+			s += " " + op + " " + showNode(n.parts[i+1])
+		}
+		return "(" + s + ")"
+	case *notNode:
+		return "!" + showNode(n.cmd)
+	case *ifNode:
+		var parts []string
+		for i := range n.conds {
+			parts = append(parts, showNode(n.conds[i]), showNode(n.bodies[i]))
+		}
+		if n.elseBody != nil {
+			parts = append(parts, "else", showNode(n.elseBody))
+		}
+		return "if(" + strings.Join(parts, " ") + ")"
+	case *loopNode:
+		keyword := "while"
+		if n.until {
+			keyword = "until"
+		}
+		return keyword + "(" + showNode(n.cond) + " " + showNode(n.body) + ")"
+	case *groupNode:
+		return showNode(n.body)
+	case *coprocNode:
+		return "coproc " + n.name + "(" + showNode(n.cmd) + ")"
+	case *funcDefNode:
+		return n.name + "()" + showNode(n.body)
+	}
+	return fmt.Sprintf("%T", n)
+}
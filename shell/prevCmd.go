@@ -1,4 +1,4 @@
-package main
+package shell
 
 import (
 	"fmt"
@@ -9,12 +9,10 @@ import (
 	"golang.org/x/term"
 )
 
-var historyIndex int // Add this at the top-level, outside any function
-
-func handleInput(prompt string) string {
+func (sh *Interpreter) handleInput(prompt string) string {
 	var input strings.Builder
-	// historyIndex is now a package-level variable, always set to hist.Len() before each input
-	// historyIndex := hist.Len() // REMOVE this line
+
+	sh.reportFinishedJobs()
 
 	fd := int(os.Stdin.Fd())
 	oldState, err := term.MakeRaw(fd)
@@ -33,8 +31,10 @@ func handleInput(prompt string) string {
 		var buf [1]byte
 		n, err := os.Stdin.Read(buf[:])
 		if err != nil || n == 0 {
-			fmt.Fprintln(os.Stderr, "Read error:", err)
-			return ""
+			// stdin is gone; leave the way exit does, with the last status
+			term.Restore(fd, oldState)
+			sh.ExitCommand([]string{"exit"}, os.Stdin, os.Stdout)
+			sh.leave(sh.exit())
 		}
 
 		char := buf[0]
@@ -52,10 +52,18 @@ func handleInput(prompt string) string {
 				fmt.Print("\b \b") // Move back, overwrite with space, move back again
 			}
 
-		case 3: // Ctrl+C
-			fmt.Print("\n")
-			term.Restore(fd, oldState)
-			os.Exit(0)
+		case 4: // Ctrl+D on an empty line
+			if input.Len() == 0 {
+				fmt.Print("\r\n")
+				term.Restore(fd, oldState)
+				sh.ExitCommand([]string{"exit"}, os.Stdin, os.Stdout)
+				sh.leave(sh.exit())
+			}
+
+		case 3: // Ctrl+C abandons the line, it doesn't kill the shell
+			fmt.Print("^C\r\n")
+			sh.lastStatus = 130
+			return ""
 
 		case 9: // Tab key
 			currInput := input.String()
@@ -65,7 +73,7 @@ func handleInput(prompt string) string {
 				tabCount = 1
 				lastTabInput = currInput
 			}
-			completions := trie.AutoComplete(currInput)
+			completions := sh.trie.AutoComplete(currInput)
 			if len(completions) == 0 {
 				fmt.Print("\a") // Bell sound
 				break
@@ -119,24 +127,24 @@ func handleInput(prompt string) string {
 			if buf2[0] == '[' {
 				switch buf2[1] {
 				case 'A': // Up arrow
-					if historyIndex > 0 && hist.Len() > 0 {
-						historyIndex--
-						if cmd, ok := hist.Get(historyIndex); ok {
+					if sh.historyIndex > 0 && sh.hist.Len() > 0 {
+						sh.historyIndex--
+						if cmd, ok := sh.hist.Get(sh.historyIndex); ok {
 							input.Reset()
 							input.WriteString(cmd)
 							redrawInput(prompt, cmd) // Redraw prompt and recalled command
 						}
 					}
 				case 'B': // Down arrow
-					if historyIndex < hist.Len()-1 {
-						historyIndex++
-						if cmd, ok := hist.Get(historyIndex); ok {
+					if sh.historyIndex < sh.hist.Len()-1 {
+						sh.historyIndex++
+						if cmd, ok := sh.hist.Get(sh.historyIndex); ok {
 							input.Reset()
 							input.WriteString(cmd)
 							redrawInput(prompt, cmd)
 						}
 					} else {
-						historyIndex = hist.Len()
+						sh.historyIndex = sh.hist.Len()
 						input.Reset()
 						redrawInput(prompt, "")
 					}
@@ -0,0 +1,146 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadCommand reads a line from standard input, or from the descriptor given
+// with -u, and splits it on IFS into the named variables. The last one gets
+// the rest of the line, and with no names the whole line goes to REPLY.
+func (sh *Interpreter) ReadCommand(argv []string, fds FdTable) int {
+	errOut := fds.writer(2)
+	raw := false
+	fd := 0
+	prompt := ""
+	i := 1
+	for ; i < len(argv) && len(argv[i]) > 1 && argv[i][0] == '-'; i++ {
+		if argv[i] == "--" {
+			i++
+			break
+		}
+		for j := 1; j < len(argv[i]); j++ {
+			c := argv[i][j]
+			if c == 'r' {
+				raw = true
+				continue
+			}
+			if c != 'u' && c != 'p' {
+				fmt.Fprintf(errOut, "read: -%c: invalid option\n", c)
+				return 2
+			}
+			// The value is the rest of the word, or the next word
+			value := argv[i][j+1:]
+			if value == "" {
+				if i+1 >= len(argv) {
+					fmt.Fprintf(errOut, "read: -%c: option requires an argument\n", c)
+					return 2
+				}
+				i++
+				value = argv[i]
+			}
+			if c == 'p' {
+				prompt = value
+			} else {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					fmt.Fprintf(errOut, "read: %s: invalid file descriptor specification\n", value)
+					return 1
+				}
+				fd = n
+			}
+			break
+		}
+	}
+	names := argv[i:]
+	for _, name := range names {
+		if !nameRe.MatchString(name) {
+			fmt.Fprintf(errOut, "read: `%s': not a valid identifier\n", name)
+			return 1
+		}
+		if sh.restrictedVar(name) {
+			fmt.Fprintf(errOut, "read: %s: readonly variable\n", name)
+			return 1
+		}
+	}
+	in := fds[fd]
+	if in == nil {
+		fmt.Fprintf(errOut, "read: %d: invalid file descriptor: %s\n", fd, errBadFd)
+		return 1
+	}
+	if prompt != "" {
+		fmt.Fprint(errOut, prompt)
+	}
+
+	line, err := readLine(in, raw)
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(errOut, "read: read error: %d: %s\n", fd, err)
+		return 1
+	}
+	if len(names) == 0 {
+		sh.setVar("REPLY", line)
+	} else {
+		sh.assignFields(names, line)
+	}
+	if err == io.EOF {
+		return 1
+	}
+	return 0
+}
+
+// readLine reads up to a newline one byte at a time, so that nothing after
+// it is taken from a pipe the next command will read. Unless raw is set, a
+// backslash quotes the next character and joins a line to the next one.
+func readLine(in io.Reader, raw bool) (string, error) {
+	var line strings.Builder
+	buf := make([]byte, 1)
+	escaped := false
+	for {
+		n, err := in.Read(buf)
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			return line.String(), err
+		}
+		c := buf[0]
+		switch {
+		case escaped:
+			escaped = false
+			if c == '\n' {
+				continue
+			}
+		case c == '\\' && !raw:
+			escaped = true
+			continue
+		case c == '\n':
+			return line.String(), nil
+		}
+		line.WriteByte(c)
+	}
+}
+
+// assignFields splits line on IFS among names, leaving the rest of the line
+// to the last one.
+func (sh *Interpreter) assignFields(names []string, line string) {
+	ifs, ok := sh.lookupShellVar("IFS")
+	if !ok {
+		ifs = " \t\n"
+	}
+	isSep := func(r rune) bool { return strings.ContainsRune(ifs, r) }
+	rest := strings.TrimLeftFunc(line, isSep)
+	for i, name := range names {
+		if i == len(names)-1 {
+			sh.setVar(name, strings.TrimRightFunc(rest, isSep))
+			break
+		}
+		end := strings.IndexFunc(rest, isSep)
+		if end < 0 {
+			end = len(rest)
+		}
+		sh.setVar(name, rest[:end])
+		rest = strings.TrimLeftFunc(rest[end:], isSep)
+	}
+}
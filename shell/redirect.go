@@ -0,0 +1,470 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// FdTable maps file descriptor numbers to what a command sees on them.
+// A key that is present with a nil value is a closed descriptor.
+type FdTable map[int]io.ReadWriter
+
+func defaultFds() FdTable {
+	return FdTable{0: os.Stdin, 1: os.Stdout, 2: os.Stderr}
+}
+
+func (t FdTable) Clone() FdTable {
+	c := make(FdTable, len(t))
+	for fd, f := range t {
+		c[fd] = f
+	}
+	return c
+}
+
+// closedFd stands in for a closed descriptor when a builtin writes to it.
+type closedFd struct{}
+
+func (closedFd) Read([]byte) (int, error)  { return 0, errBadFd }
+func (closedFd) Write([]byte) (int, error) { return 0, errBadFd }
+
+var errBadFd = errors.New("Bad file descriptor")
+
+// reader and writer are for builtins, which always need something to call.
+func (t FdTable) reader(fd int) io.Reader {
+	if f := t[fd]; f != nil {
+		return f
+	}
+	return closedFd{}
+}
+
+func (t FdTable) writer(fd int) io.Writer {
+	if f := t[fd]; f != nil {
+		return f
+	}
+	return closedFd{}
+}
+
+// attach wires the table into an external command. Descriptors above 2 are
+// only passed on when they are real files, and not where private says the
+// shell keeps its own, like a coprocess's pipes.
+func (t FdTable) attach(cmd *exec.Cmd, private func(fd int, f *os.File) bool) {
+	if f := t[0]; f != nil {
+		cmd.Stdin = f
+	}
+	if f := t[1]; f != nil {
+		cmd.Stdout = f
+	}
+	if f := t[2]; f != nil {
+		cmd.Stderr = f
+	}
+	max := 2
+	for fd := range t {
+		if fd > max {
+			max = fd
+		}
+	}
+	cmd.ExtraFiles = nil
+	for fd := 3; fd <= max; fd++ {
+		f, _ := t[fd].(*os.File)
+		if private(fd, f) {
+			f = nil
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	}
+}
+
+func (t FdTable) holds(f io.ReadWriter) bool {
+	for _, g := range t {
+		if g == f {
+			return true
+		}
+	}
+	return false
+}
+
+// adoptFds makes fds the shell's own table. Files that nothing refers to
+// any more, including ones just opened by the redirections, are closed.
+func (sh *Interpreter) adoptFds(fds FdTable, opened []*os.File) {
+	candidates := opened
+	for _, f := range sh.fds {
+		if file, ok := f.(*os.File); ok {
+			candidates = append(candidates, file)
+		}
+	}
+	sh.fds = fds
+	for _, f := range candidates {
+		if f == os.Stdin || f == os.Stdout || f == os.Stderr || fds.holds(f) {
+			continue
+		}
+		f.Close()
+	}
+}
+
+// restoreFds puts back saved, the shell's table from before a function or
+// sourced file that ran with entry. What exec changed meanwhile stays,
+// except on the descriptors the call redirected for itself.
+func (sh *Interpreter) restoreFds(saved, entry FdTable) {
+	changed := func(a, b FdTable, fd int) bool {
+		f, inA := a[fd]
+		g, inB := b[fd]
+		return inA != inB || f != g
+	}
+	fds := saved.Clone()
+	for _, t := range []FdTable{saved, entry, sh.fds} {
+		for fd := range t {
+			if changed(entry, saved, fd) || !changed(sh.fds, entry, fd) {
+				continue
+			}
+			if f, ok := sh.fds[fd]; ok {
+				fds[fd] = f
+			} else {
+				delete(fds, fd)
+			}
+		}
+	}
+	sh.fds = fds
+}
+
+// installFds makes the process's real descriptors match the table, so that
+// a program started with syscall.Exec sees them.
+func installFds(fds FdTable) error {
+	max := 2
+	for fd := range fds {
+		if fd > max {
+			max = fd
+		}
+	}
+	// Park every source above the targets first, so one dup2 can't clobber
+	// the source of another.
+	parked := map[int]int{}
+	for fd, f := range fds {
+		if f == nil {
+			continue
+		}
+		file, ok := f.(*os.File)
+		if !ok {
+			return fmt.Errorf("%d: not a file descriptor", fd)
+		}
+		nfd, err := unix.FcntlInt(file.Fd(), unix.F_DUPFD_CLOEXEC, max+1)
+		if err != nil {
+			return fmt.Errorf("%d: %w", fd, err)
+		}
+		parked[fd] = nfd
+	}
+	for fd := range fds {
+		src, ok := parked[fd]
+		if !ok {
+			unix.Close(fd)
+			continue
+		}
+		if err := unix.Dup2(src, fd); err != nil {
+			return fmt.Errorf("%d: %w", fd, err)
+		}
+	}
+	return nil
+}
+
+type redirect struct {
+	fd     int // -1 when the operator's default applies
+	op     string
+	target string
+}
+
+var redirectRe = regexp.MustCompile(`^([0-9]*)(>>|>\||>&|>|<&|<)(.*)$`)
+var redirectBothRe = regexp.MustCompile(`^(&>>|&>)(.*)$`)
+
+func parseRedirect(word string) (redirect, bool) {
+	if m := redirectBothRe.FindStringSubmatch(word); m != nil {
+		return redirect{fd: -1, op: m[1], target: m[2]}, true
+	}
+	m := redirectRe.FindStringSubmatch(word)
+	if m == nil {
+		return redirect{}, false
+	}
+	fd := -1
+	if m[1] != "" {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return redirect{}, false
+		}
+		fd = n
+	}
+	return redirect{fd: fd, op: m[2], target: m[3]}, true
+}
+
+func errRestrictedOutput(target string) error {
+	return fmt.Errorf("%s: restricted: cannot redirect output", target)
+}
+
+// redirectWord is parseRedirect for a word of a command line, which is only
+// a redirection when its operator was typed as it is. The descriptor and
+// the target may come from quotes or expansions.
+func redirectWord(w word) (redirect, bool) {
+	r, ok := parseRedirect(w.text)
+	if !ok {
+		return redirect{}, false
+	}
+	end := len(w.text) - len(r.target)
+	for i := end - len(r.op); i < end; i++ {
+		if !w.typed[i] {
+			return redirect{}, false
+		}
+	}
+	return r, true
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// createOutput opens a file for ">" style redirections. With noclobber set
+// it refuses to truncate an existing regular file unless force is given (>|).
+func (sh *Interpreter) createOutput(name string, force bool) (*os.File, error) {
+	if !sh.optionOn("noclobber") || force {
+		f, err := os.Create(sh.resolve(name))
+		if err != nil {
+			return nil, fmt.Errorf("Error opening output file: %w", err)
+		}
+		return f, nil
+	}
+	f, err := os.OpenFile(sh.resolve(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("Error opening output file: %w", err)
+	}
+	// Devices, fifos and the like are fine to write to, just don't truncate them
+	if info, statErr := os.Stat(sh.resolve(name)); statErr == nil && !info.Mode().IsRegular() {
+		f, err = os.OpenFile(sh.resolve(name), os.O_WRONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening output file: %w", err)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("%s: cannot overwrite existing file", name)
+}
+
+// fanOut copies every write to all of its targets, like tee. A target that
+// fails is dropped so the others still get the data.
+type fanOut struct {
+	targets []io.Writer
+}
+
+func newFanOut(targets []io.ReadWriter) *fanOut {
+	f := &fanOut{}
+	for _, t := range targets {
+		f.targets = append(f.targets, t)
+	}
+	return f
+}
+
+func (f *fanOut) Write(p []byte) (int, error) {
+	live := f.targets[:0]
+	for _, t := range f.targets {
+		if _, err := t.Write(p); err == nil {
+			live = append(live, t)
+		}
+	}
+	f.targets = live
+	if len(live) == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
+
+func (f *fanOut) Read([]byte) (int, error) { return 0, errBadFd }
+
+// fanIn reads its sources one after the other.
+type fanIn struct {
+	io.Reader
+}
+
+func newFanIn(sources []io.ReadWriter) *fanIn {
+	readers := make([]io.Reader, len(sources))
+	for i, s := range sources {
+		readers[i] = s
+	}
+	return &fanIn{io.MultiReader(readers...)}
+}
+
+func (f *fanIn) Write([]byte) (int, error) { return 0, errBadFd }
+
+func isFan(stream any) bool {
+	switch stream.(type) {
+	case *fanOut, *fanIn:
+		return true
+	}
+	return false
+}
+
+// multiosEnabled reports whether several redirections of one descriptor
+// should all take effect, as in zsh, instead of the last one winning. It is
+// off unless set -o multios asks for it, since scripts written for bash
+// count on 2>&1 >file leaving only stderr in a pipe.
+func (sh *Interpreter) multiosEnabled() bool {
+	return sh.optionOn("multios") && !sh.optionOn("posix")
+}
+
+// HandleRedirect applies the redirections in argv from left to right on top of base.
+// It returns the remaining argv, the resulting fd table, and the files it opened,
+// which the caller must close once the command is done.
+func (sh *Interpreter) HandleRedirect(argv []string, base FdTable) ([]string, FdTable, []*os.File, error) {
+	words := make([]word, len(argv))
+	for i, arg := range argv {
+		words[i] = typedWord(arg)
+	}
+	return sh.applyRedirects(words, base, nil)
+}
+
+// applyRedirects is HandleRedirect for pipeline stages. The descriptors in
+// implicit are connected to a pipe, which counts as their first redirection.
+func (sh *Interpreter) applyRedirects(argv []word, base FdTable, implicit []int) ([]string, FdTable, []*os.File, error) {
+	fds := base.Clone()
+	var opened []*os.File
+	cleaned := []string{}
+	fail := func(err error) ([]string, FdTable, []*os.File, error) {
+		closeFiles(opened)
+		return nil, nil, nil, err
+	}
+	// What this command has sent each descriptor to, or read it from, so far
+	outs := map[int][]io.ReadWriter{}
+	ins := map[int][]io.ReadWriter{}
+	for _, fd := range implicit {
+		if fd == 0 {
+			ins[fd] = []io.ReadWriter{fds[fd]}
+		} else {
+			outs[fd] = []io.ReadWriter{fds[fd]}
+		}
+	}
+	setOut := func(fd int, w io.ReadWriter) {
+		delete(ins, fd)
+		if slices.Contains(outs[fd], w) {
+			return // already one of its targets, which would get everything twice
+		}
+		if sh.multiosEnabled() && len(outs[fd]) > 0 {
+			outs[fd] = append(outs[fd], w)
+			fds[fd] = newFanOut(outs[fd])
+			return
+		}
+		outs[fd] = []io.ReadWriter{w}
+		fds[fd] = w
+	}
+	setIn := func(fd int, r io.ReadWriter) {
+		delete(outs, fd)
+		if slices.Contains(ins[fd], r) {
+			return
+		}
+		if sh.multiosEnabled() && len(ins[fd]) > 0 {
+			ins[fd] = append(ins[fd], r)
+			fds[fd] = newFanIn(ins[fd])
+			return
+		}
+		ins[fd] = []io.ReadWriter{r}
+		fds[fd] = r
+	}
+	for i := 0; i < len(argv); i++ {
+		r, ok := redirectWord(argv[i])
+		if !ok {
+			cleaned = append(cleaned, argv[i].text)
+			continue
+		}
+		if r.target == "" {
+			if i+1 >= len(argv) {
+				return fail(fmt.Errorf("syntax error near unexpected token `newline'"))
+			}
+			i++ // skip filename
+			r.target = argv[i].text
+		}
+		switch r.op {
+		case ">", ">|", ">>", "&>", "&>>":
+			if sh.optionOn("restricted") {
+				return fail(errRestrictedOutput(r.target))
+			}
+			var f *os.File
+			var err error
+			if r.op == ">>" || r.op == "&>>" {
+				f, err = os.OpenFile(sh.resolve(r.target), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					err = fmt.Errorf("Error opening output file for append: %w", err)
+				}
+			} else {
+				f, err = sh.createOutput(r.target, r.op == ">|")
+			}
+			if err != nil {
+				return fail(err)
+			}
+			opened = append(opened, f)
+			if r.op[0] == '&' {
+				setOut(1, f)
+				setOut(2, f)
+			} else if r.fd < 0 {
+				setOut(1, f)
+			} else {
+				setOut(r.fd, f)
+			}
+		case "<":
+			f, err := os.Open(sh.resolve(r.target))
+			if err != nil {
+				return fail(fmt.Errorf("Error opening input file: %w", err))
+			}
+			opened = append(opened, f)
+			if r.fd < 0 {
+				r.fd = 0
+			}
+			setIn(r.fd, f)
+		case ">&", "<&":
+			bare := r.fd < 0
+			if r.fd < 0 {
+				r.fd = 1
+				if r.op == "<&" {
+					r.fd = 0
+				}
+			}
+			if r.target == "-" {
+				delete(outs, r.fd)
+				delete(ins, r.fd)
+				fds[r.fd] = nil
+				continue
+			}
+			src, err := strconv.Atoi(r.target)
+			if err != nil {
+				// ">&file" is the old spelling of "&>file"
+				if r.op == ">&" && bare {
+					if sh.optionOn("restricted") {
+						return fail(errRestrictedOutput(r.target))
+					}
+					f, err := sh.createOutput(r.target, false)
+					if err != nil {
+						return fail(err)
+					}
+					opened = append(opened, f)
+					setOut(1, f)
+					setOut(2, f)
+					continue
+				}
+				return fail(fmt.Errorf("%s: ambiguous redirect", r.target))
+			}
+			f, ok := fds[src]
+			if !ok || f == nil {
+				return fail(fmt.Errorf("%d: %s", src, errBadFd))
+			}
+			if r.op == "<&" {
+				setIn(r.fd, f)
+			} else {
+				setOut(r.fd, f)
+			}
+		}
+	}
+	return cleaned, fds, opened, nil
+}
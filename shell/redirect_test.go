@@ -0,0 +1,257 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyRedirects(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		set      []string          // set -o/+o arguments
+		vars     map[string]string // variables the line expands
+		files    map[string]string // files that exist beforehand
+		implicit []int             // descriptors connected to a pipe
+
+		wantArgs   []string
+		wantOut    string // what writing "1\n" to fd 1 and "2\n" to fd 2 sends to the base table
+		wantErrOut string
+		wantFiles  map[string]string
+		wantIn     string // what fd 0 reads, when set
+		wantClosed []int
+		wantErr    string
+	}{
+		{
+			name: "output then duplicate", line: "echo a >f 2>&1",
+			wantArgs: []string{"echo", "a"}, wantFiles: map[string]string{"f": "1\n2\n"},
+		},
+		{
+			name: "duplicate then output", line: "echo a 2>&1 >f",
+			wantArgs: []string{"echo", "a"}, wantOut: "2\n", wantFiles: map[string]string{"f": "1\n"},
+		},
+		{
+			name: "target in the next word", line: "cmd > f 2> g",
+			wantArgs: []string{"cmd"}, wantFiles: map[string]string{"f": "1\n", "g": "2\n"},
+		},
+		{
+			name: "close stderr", line: "cmd 2>&-",
+			wantArgs: []string{"cmd"}, wantOut: "1\n", wantClosed: []int{2},
+		},
+		{
+			name: "close stdout", line: "cmd >&- 0<&-",
+			wantArgs: []string{"cmd"}, wantErrOut: "2\n", wantClosed: []int{0, 1},
+		},
+		{
+			name: "duplicate a closed descriptor", line: "cmd 2>&5",
+			wantErr: "5: Bad file descriptor",
+		},
+		{
+			name: "duplicate a word", line: "cmd 2>&foo",
+			wantErr: "foo: ambiguous redirect",
+		},
+		{
+			name: "both", line: "cmd &>f",
+			wantArgs: []string{"cmd"}, files: map[string]string{"f": "old\n"}, wantFiles: map[string]string{"f": "1\n2\n"},
+		},
+		{
+			name: "both, old spelling", line: "cmd >&f",
+			wantArgs: []string{"cmd"}, wantFiles: map[string]string{"f": "1\n2\n"},
+		},
+		{
+			name: "append", line: "cmd >>f",
+			wantArgs: []string{"cmd"}, files: map[string]string{"f": "old\n"},
+			wantErrOut: "2\n", wantFiles: map[string]string{"f": "old\n1\n"},
+		},
+		{
+			name: "append both", line: "cmd &>>f",
+			wantArgs: []string{"cmd"}, files: map[string]string{"f": "old\n"}, wantFiles: map[string]string{"f": "old\n1\n2\n"},
+		},
+		{
+			name: "input", line: "cat <in",
+			wantArgs: []string{"cat"}, files: map[string]string{"in": "a\n"}, wantOut: "1\n", wantErrOut: "2\n", wantIn: "a\n",
+		},
+		{
+			name: "missing input", line: "cat <in",
+			wantErr: "Error opening input file",
+		},
+		{
+			name: "missing target", line: "cmd >",
+			wantErr: "syntax error near unexpected token `newline'",
+		},
+
+		{
+			name: "noclobber", line: "cmd >f", set: []string{"-o", "noclobber"},
+			files: map[string]string{"f": "old\n"}, wantErr: "f: cannot overwrite existing file",
+			wantFiles: map[string]string{"f": "old\n"},
+		},
+		{
+			name: "noclobber, forced", line: "cmd >|f", set: []string{"-C"},
+			wantArgs: []string{"cmd"}, files: map[string]string{"f": "old\n"},
+			wantErrOut: "2\n", wantFiles: map[string]string{"f": "1\n"},
+		},
+		{
+			name: "noclobber, new file", line: "cmd >f", set: []string{"-o", "noclobber"},
+			wantArgs: []string{"cmd"}, wantErrOut: "2\n", wantFiles: map[string]string{"f": "1\n"},
+		},
+		{
+			name: "noclobber, append", line: "cmd >>f", set: []string{"-o", "noclobber"},
+			wantArgs: []string{"cmd"}, files: map[string]string{"f": "old\n"},
+			wantErrOut: "2\n", wantFiles: map[string]string{"f": "old\n1\n"},
+		},
+		{
+			name: "noclobber, device", line: "cmd >/dev/null", set: []string{"-o", "noclobber"},
+			wantArgs: []string{"cmd"}, wantErrOut: "2\n",
+		},
+
+		{
+			name: "multios fan-out", line: "cmd >a >b", set: []string{"-o", "multios"},
+			wantArgs: []string{"cmd"}, wantErrOut: "2\n", wantFiles: map[string]string{"a": "1\n", "b": "1\n"},
+		},
+		{
+			name: "multios fan-out after a duplicate", line: "cmd >a 2>&1 >b", set: []string{"-o", "multios"},
+			wantArgs: []string{"cmd"}, wantFiles: map[string]string{"a": "1\n2\n", "b": "1\n"},
+		},
+		{
+			name: "multios fan-out with a pipe", line: "cmd >f", implicit: []int{1}, set: []string{"-o", "multios"},
+			wantArgs: []string{"cmd"}, wantOut: "1\n", wantErrOut: "2\n", wantFiles: map[string]string{"f": "1\n"},
+		},
+		{
+			name: "multios fan-in", line: "cat <a <b", set: []string{"-o", "multios"},
+			files:    map[string]string{"a": "A\n", "b": "B\n"},
+			wantArgs: []string{"cat"}, wantOut: "1\n", wantErrOut: "2\n", wantIn: "A\nB\n",
+		},
+		{
+			name: "multios, a target given twice", line: "echo q 2>&1 1>&2", implicit: []int{1}, set: []string{"-o", "multios"},
+			wantArgs: []string{"echo", "q"}, wantOut: "1\n2\n",
+		},
+		{
+			name: "last output wins without multios", line: "cmd >a >b",
+			wantArgs: []string{"cmd"}, wantErrOut: "2\n", wantFiles: map[string]string{"a": "", "b": "1\n"},
+		},
+		{
+			name: "pipe replaced without multios", line: "ls 2>&1 >f", implicit: []int{1},
+			wantArgs: []string{"ls"}, wantOut: "2\n", wantFiles: map[string]string{"f": "1\n"},
+		},
+		{
+			name: "last input wins without multios", line: "cat <a <b",
+			files:    map[string]string{"a": "A\n", "b": "B\n"},
+			wantArgs: []string{"cat"}, wantOut: "1\n", wantErrOut: "2\n", wantIn: "B\n",
+		},
+		{
+			name: "close after fan-out", line: "cmd >a >b >&-", set: []string{"-o", "multios"},
+			wantArgs: []string{"cmd"}, wantErrOut: "2\n", wantClosed: []int{1}, wantFiles: map[string]string{"a": "", "b": ""},
+		},
+
+		// Only redirection operators typed plainly count
+		{
+			name: "quoted operators", line: `echo "2>&1" '>f' \>g "<div>"`,
+			wantArgs: []string{"echo", "2>&1", ">f", ">g", "<div>"}, wantOut: "1\n", wantErrOut: "2\n",
+		},
+		{
+			name: "expanded operator", line: "echo $x", vars: map[string]string{"x": ">f"},
+			wantArgs: []string{"echo", ">f"}, wantOut: "1\n", wantErrOut: "2\n",
+		},
+		{
+			name: "quoted target", line: `echo 2>"my file"`,
+			wantArgs: []string{"echo"}, wantOut: "1\n", wantFiles: map[string]string{"my file": "2\n"},
+		},
+		{
+			name: "expanded target", line: "echo >$x", vars: map[string]string{"x": "f"},
+			wantArgs: []string{"echo"}, wantErrOut: "2\n", wantFiles: map[string]string{"f": "1\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sh := New(WithDir(dir))
+			if len(tt.set) > 0 {
+				if status := sh.SetCommand(append([]string{"set"}, tt.set...), FdTable{1: asOutput(io.Discard), 2: asOutput(io.Discard)}); status != 0 {
+					t.Fatalf("set %s failed", strings.Join(tt.set, " "))
+				}
+			}
+			for name, value := range tt.vars {
+				sh.setVar(name, value)
+			}
+			var out, errOut bytes.Buffer
+			base := FdTable{0: asInput(strings.NewReader("stdin\n")), 1: asOutput(&out), 2: asOutput(&errOut)}
+
+			args, fds, opened, err := sh.applyRedirects(sh.splitWords(tt.line), base, tt.implicit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(args, tt.wantArgs) {
+					t.Errorf("args = %q, want %q", args, tt.wantArgs)
+				}
+				for _, fd := range tt.wantClosed {
+					if fds[fd] != nil {
+						t.Errorf("fd %d is open", fd)
+					}
+				}
+				if !slices.Contains(tt.wantClosed, 1) {
+					io.WriteString(fds.writer(1), "1\n")
+				}
+				if !slices.Contains(tt.wantClosed, 2) {
+					io.WriteString(fds.writer(2), "2\n")
+				}
+				if tt.wantIn != "" {
+					in, _ := io.ReadAll(fds.reader(0))
+					if string(in) != tt.wantIn {
+						t.Errorf("fd 0 read %q, want %q", in, tt.wantIn)
+					}
+				}
+				closeFiles(opened)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("stdout got %q, want %q", out.String(), tt.wantOut)
+			}
+			if errOut.String() != tt.wantErrOut {
+				t.Errorf("stderr got %q, want %q", errOut.String(), tt.wantErrOut)
+			}
+			for name, want := range tt.wantFiles {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+				} else if string(got) != want {
+					t.Errorf("%s holds %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRedirectsLeavesBase(t *testing.T) {
+	sh := New(WithDir(t.TempDir()))
+	var out bytes.Buffer
+	base := FdTable{1: asOutput(&out), 2: asOutput(&out)}
+	stdout, stderr := base[1], base[2]
+	_, fds, opened, err := sh.applyRedirects(sh.splitWords("cmd >f 2>&-"), base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFiles(opened)
+	if fds[1] == base[1] || fds[2] != nil {
+		t.Errorf("redirections didn't apply: %v", fds)
+	}
+	if base[1] != stdout || base[2] != stderr {
+		t.Errorf("base table changed: %v", base)
+	}
+	if len(opened) != 1 {
+		t.Errorf("opened %d files, want 1", len(opened))
+	}
+}
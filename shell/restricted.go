@@ -0,0 +1,96 @@
+package shell
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A restricted shell, started with -r or as rbash, keeps its user to the
+// programs in the PATH it was given: it won't change directory, run a
+// command by its path, write files through redirections or exec, and the
+// variables that decide what runs can't be changed. Restrictions start
+// once the startup files have been read, and can't be lifted.
+
+// restrictedVars are the variables a restricted shell treats as read-only.
+var restrictedVars = []string{"SHELL", "PATH", "ENV", "BASH_ENV", "HISTFILE"}
+
+func (sh *Interpreter) restrictedVar(name string) bool {
+	return sh.optionOn("restricted") && slices.Contains(restrictedVars, name)
+}
+
+// restrict makes i a restricted shell.
+func (i *Interpreter) restrict() {
+	for _, o := range i.options {
+		if o.name == "restricted" {
+			o.on = true
+		}
+	}
+}
+
+// restrictCommand returns why a restricted shell won't run argv, its
+// NAME=value prefixes included, or nil if it may.
+func (sh *Interpreter) restrictCommand(argv []string) error {
+	if !sh.optionOn("restricted") {
+		return nil
+	}
+	assigns, argv := splitAssignments(argv)
+	for _, kv := range assigns {
+		if name, _, _ := strings.Cut(kv, "="); sh.restrictedVar(name) {
+			return fmt.Errorf("%s: readonly variable", name)
+		}
+	}
+	if len(argv) == 0 {
+		return nil
+	}
+	name := argv[0]
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("%s: restricted: cannot specify `/' in command names", name)
+	}
+	if _, ok := sh.functions[name]; ok {
+		return nil
+	}
+	switch name {
+	case "cd", "exec":
+		return fmt.Errorf("%s: restricted", name)
+	case "source", ".", "history":
+		// Files are only read or written by name, in the current directory
+		for _, arg := range argv[1:] {
+			if strings.Contains(arg, "/") {
+				return fmt.Errorf("%s: %s: restricted", name, arg)
+			}
+		}
+	case "hash":
+		if slices.Contains(argv[1:], "-p") {
+			return fmt.Errorf("hash: -p: restricted")
+		}
+	case "env":
+		// env runs what follows its options and assignments
+		for i := 1; i < len(argv); i++ {
+			switch arg := argv[i]; {
+			case arg == "-i" || arg == "-":
+			case arg == "-u":
+				i++
+			case isAssignment(arg):
+			default:
+				return sh.restrictCommand(argv[i:])
+			}
+		}
+	case "sandbox":
+		for i := 1; i < len(argv); i++ {
+			switch argv[i] {
+			case "-f":
+				i++
+			case "--":
+				return sh.restrictCommand(argv[i+1:])
+			default:
+				return sh.restrictCommand(argv[i:])
+			}
+		}
+	case "ulimit":
+		if i := slices.Index(argv, "--"); i >= 0 && i+1 < len(argv) {
+			return sh.restrictCommand(argv[i+1:])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unwinding reports whether the rest of a list should be skipped.
+func (sh *Interpreter) unwinding() bool {
+	return sh.returning || sh.exiting || sh.interrupted || sh.breakLevels > 0 || sh.continueLevels > 0 || sh.cancelled()
+}
+
+func (sh *Interpreter) runList(list *listNode) int {
+	for _, item := range list.items {
+		if sh.unwinding() {
+			break
+		}
+		if item.background {
+			pl, ok := item.cmd.(*pipelineNode)
+			if !ok {
+				fmt.Fprintln(sh.fds.writer(2), "only pipelines can run in the background")
+				sh.lastStatus = 2
+				continue
+			}
+			sh.lastStatus = sh.startJob(pl.text)
+			continue
+		}
+		sh.lastStatus = sh.runNode(item.cmd)
+		sh.runPendingTraps()
+	}
+	return sh.lastStatus
+}
+
+// runIgnoringErrexit runs n in a context where set -e doesn't apply.
+func (sh *Interpreter) runIgnoringErrexit(n node) int {
+	sh.errexitIgnored++
+	defer func() { sh.errexitIgnored-- }()
+	return sh.runNode(n)
+}
+
+func (sh *Interpreter) runNode(n node) int {
+	switch n := n.(type) {
+	case *listNode:
+		return sh.runList(n)
+	case *pipelineNode:
+		return sh.runPipeline(n.text)
+	case *andOrNode:
+		status := sh.runIgnoringErrexit(n.parts[0])
+		for i, op := range n.ops {
+			if (op == "&&") != (status == 0) || sh.unwinding() {
+				continue
+			}
+			sh.lastStatus = status
+			if i == len(n.ops)-1 {
+				status = sh.runNode(n.parts[i+1])
+			} else {
+				status = sh.runIgnoringErrexit(n.parts[i+1])
+			}
+		}
+		return status
+	case *notNode:
+		if sh.runIgnoringErrexit(n.cmd) == 0 {
+			return 1
+		}
+		return 0
+	case *ifNode:
+		for i, cond := range n.conds {
+			status := sh.runIgnoringErrexit(cond)
+			if sh.unwinding() {
+				return status
+			}
+			if status == 0 {
+				return sh.runList(n.bodies[i])
+			}
+		}
+		if n.elseBody != nil {
+			return sh.runList(n.elseBody)
+		}
+		return 0
+	case *loopNode:
+		return sh.runLoop(n)
+	case *groupNode:
+		return sh.runList(n.body)
+	case *coprocNode:
+		return sh.startCoproc(n.name, n.cmd.text, n.text)
+	case *funcDefNode:
+		sh.functions[n.name] = &shellFunc{name: n.name, body: n.body, text: n.text}
+		if sh.trie != nil {
+			sh.trie.insert(n.name)
+		}
+		return 0
+	}
+	return 0
+}
+
+func (sh *Interpreter) runLoop(n *loopNode) int {
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+	status := 0
+	for {
+		cond := sh.runIgnoringErrexit(n.cond)
+		if sh.unwinding() || (cond == 0) == n.until {
+			break
+		}
+		status = sh.runList(n.body)
+		if sh.breakLevels > 0 {
+			sh.breakLevels--
+			break
+		}
+		if sh.continueLevels > 0 {
+			sh.continueLevels--
+			if sh.continueLevels > 0 {
+				break // an outer loop is the one to continue
+			}
+		}
+		if sh.unwinding() {
+			break
+		}
+	}
+	return status
+}
+
+// runPipeline runs one pipeline, and then does what set -e and the ERR trap
+// call for if it failed.
+func (sh *Interpreter) runPipeline(text string) int {
+	if sh.funcDepth == 0 {
+		sh.beforeCommand(text)
+	}
+	status := sh.runCommand(text)
+	if status == 0 || sh.errexitIgnored > 0 || sh.inTrap > 0 {
+		return status
+	}
+	sh.lastStatus = status
+	if sh.funcDepth == 0 {
+		sh.runErrTrap()
+	}
+	if sh.optionOn("errexit") {
+		sh.ExitCommand([]string{"exit", strconv.Itoa(status)}, os.Stdin, os.Stderr)
+	}
+	return status
+}
+
+// runCommand runs a single pipeline or simple command and returns its status.
+func (sh *Interpreter) runCommand(text string) int {
+	if line, posix, ok := isTimed(text); ok {
+		return sh.runTimed(line, posix)
+	}
+
+	if len(splitPipelineWithQuoting(text)) > 1 {
+		return sh.HandlePipe(text)
+	}
+
+	words := sh.splitWords(text)
+	if err := sh.expansionError(); err != nil {
+		return sh.failExpansion(err)
+	}
+	argv, fds, opened, err := sh.applyRedirects(words, sh.fds, nil)
+	if err != nil {
+		fmt.Fprintf(sh.fds.writer(2), "%s%s\n", sh.location(), err)
+		return 1
+	}
+	sh.traceCommand(argv)
+	if err := sh.restrictCommand(argv); err != nil {
+		fmt.Fprintf(fds.writer(2), "%s%s\n", sh.location(), err)
+		closeFiles(opened)
+		return 1
+	}
+	assigns, argv := splitAssignments(argv)
+	if len(argv) == 0 {
+		// Plain NAME=value words set shell variables
+		for _, kv := range assigns {
+			name, value, _ := strings.Cut(kv, "=")
+			sh.setVar(name, value)
+		}
+		closeFiles(opened)
+		return 0
+	}
+	if argv[0] == "exec" {
+		return sh.withAssignments(assigns, func() int { return sh.ExecCommand(argv, fds, opened) })
+	}
+
+	status := sh.withAssignments(assigns, func() int { return sh.Menu(argv[0], argv, fds) })
+	sh.pipeStatus = []int{status}
+	sh.setVar("_", argv[len(argv)-1])
+	closeFiles(opened)
+	return status
+}
+
+func (sh *Interpreter) expansionError() error {
+	if sh.unboundVar == "" {
+		return nil
+	}
+	err := fmt.Errorf("%s: unbound variable", sh.unboundVar)
+	sh.unboundVar = ""
+	return err
+}
+
+// failExpansion reports an expansion error. It ends a shell that isn't
+// interactive, and abandons the command line in one that is.
+func (sh *Interpreter) failExpansion(err error) int {
+	fmt.Fprintf(sh.fds.writer(2), "%s%s\n", sh.location(), err)
+	if !sh.interactive {
+		sh.ExitCommand([]string{"exit", "1"}, os.Stdin, os.Stderr)
+	}
+	sh.interrupted = true
+	return 1
+}
+
+// traceCommand prints argv for set -x, after PS4.
+func (sh *Interpreter) traceCommand(argv []string) {
+	if !sh.optionOn("xtrace") || len(argv) == 0 {
+		return
+	}
+	words := make([]string, len(argv))
+	for i, word := range argv {
+		words[i] = quoteWord(word)
+		if name, value, ok := strings.Cut(word, "="); ok && isAssignment(word) {
+			words[i] = name + "=" + quoteWord(value)
+		}
+	}
+	ps4, ok := sh.lookupShellVar("PS4")
+	if !ok {
+		ps4 = "+ "
+	}
+	fmt.Fprintf(sh.fds.writer(2), "%s%s\n", sh.expandVars(ps4), strings.Join(words, " "))
+}
+
+// quoteWord quotes word so that the shell would read it back as one word.
+func quoteWord(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\n'\"\\$`|&;<>(){}*?[]#~") {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// expandVars expands the $ references in s, and nothing else.
+func (sh *Interpreter) expandVars(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '$' {
+			b.WriteRune(runes[i])
+			continue
+		}
+		value, n := sh.expandDollar(runes[i+1:])
+		b.WriteString(value)
+		i += n
+	}
+	return b.String()
+}
+
+func (sh *Interpreter) BreakCommand(argv []string, fds FdTable) int {
+	errOut := fds.writer(2)
+	n := 1
+	if len(argv) > 1 {
+		var err error
+		if n, err = strconv.Atoi(argv[1]); err != nil || n < 1 {
+			fmt.Fprintf(errOut, "%s: %s: loop count out of range\n", argv[0], argv[1])
+			return 1
+		}
+	}
+	if sh.loopDepth == 0 {
+		fmt.Fprintf(errOut, "%s: only meaningful in a `for', `while', or `until' loop\n", argv[0])
+		return 0
+	}
+	n = min(n, sh.loopDepth)
+	if argv[0] == "break" {
+		sh.breakLevels = n
+	} else {
+		sh.continueLevels = n
+	}
+	return 0
+}
@@ -0,0 +1,347 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxPolicy is what a sandboxed command may do. It can read the paths
+// in read and change the ones in write, and nothing else on the
+// filesystem. Without network it gets a network namespace of its own,
+// with only a loopback interface. With privateTmp it gets an empty /tmp,
+// which it may write to, that goes away with it.
+type sandboxPolicy struct {
+	read       []string
+	write      []string
+	network    bool
+	privateTmp bool
+}
+
+// defaultSandbox is the policy until sandbox -f loads one: the whole
+// filesystem read-only, except for /dev/null and a private /tmp, and no
+// network.
+func defaultSandbox() *sandboxPolicy {
+	return &sandboxPolicy{read: []string{"/"}, write: []string{"/dev/null"}, privateTmp: true}
+}
+
+// loadSandboxPolicy reads a policy file. Each line is a directive and its
+// argument, and # starts a comment:
+//
+//	read /usr          the command may read, list and run what is under /usr
+//	write ~/src/repo   it may also create, change and remove things there
+//	network on         it keeps the network (off by default)
+//	tmp shared         it sees the real /tmp (private by default)
+//
+// Paths are made absolute, and a leading ~ is $HOME. A private /tmp hides
+// whatever the real one holds, including paths the policy names there.
+func (sh *Interpreter) loadSandboxPolicy(name string) (*sandboxPolicy, error) {
+	f, err := os.Open(sh.resolve(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := &sandboxPolicy{privateTmp: true}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: line %d: expected a directive and one argument", name, n)
+		}
+		directive, arg := fields[0], fields[1]
+		switch {
+		case directive == "read" || directive == "write":
+			path := arg
+			if path == "~" || strings.HasPrefix(path, "~/") {
+				path = sh.getVar("HOME") + path[1:]
+			}
+			if path = sh.resolve(path); !filepath.IsAbs(path) {
+				return nil, fmt.Errorf("%s: line %d: %s: not an absolute path", name, n, path)
+			}
+			path = filepath.Clean(path)
+			if directive == "read" {
+				p.read = append(p.read, path)
+			} else {
+				p.write = append(p.write, path)
+			}
+		case directive == "network" && (arg == "on" || arg == "off"):
+			p.network = arg == "on"
+		case directive == "tmp" && (arg == "private" || arg == "shared"):
+			p.privateTmp = arg == "private"
+		default:
+			return nil, fmt.Errorf("%s: line %d: bad directive %q", name, n, strings.TrimSpace(line))
+		}
+	}
+	return p, scanner.Err()
+}
+
+// print writes the policy in the file's format.
+func (p *sandboxPolicy) print(out io.Writer) {
+	for _, path := range p.read {
+		fmt.Fprintf(out, "read %s\n", path)
+	}
+	for _, path := range p.write {
+		fmt.Fprintf(out, "write %s\n", path)
+	}
+	network, tmp := "off", "shared"
+	if p.network {
+		network = "on"
+	}
+	if p.privateTmp {
+		tmp = "private"
+	}
+	fmt.Fprintf(out, "network %s\ntmp %s\n", network, tmp)
+}
+
+// specs are the trampoline settings that put a command in the sandbox.
+// The namespaces themselves come from cloneFlags.
+func (p *sandboxPolicy) specs() []string {
+	var specs []string
+	if p.privateTmp {
+		specs = append(specs, "tmpfs=/tmp")
+	}
+	if !p.network {
+		specs = append(specs, "loopback")
+	}
+	for _, path := range p.read {
+		specs = append(specs, "landlock=r:"+path)
+	}
+	for _, path := range p.write {
+		specs = append(specs, "landlock=w:"+path)
+	}
+	if p.privateTmp {
+		specs = append(specs, "landlock=w:/tmp")
+	}
+	return specs
+}
+
+func (p *sandboxPolicy) cloneFlags() uintptr {
+	var flags uintptr
+	if !p.network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	if p.privateTmp {
+		flags |= syscall.CLONE_NEWNS
+	}
+	// Anyone but root needs a user namespace to be allowed the others
+	if flags != 0 && os.Geteuid() != 0 {
+		flags |= syscall.CLONE_NEWUSER
+	}
+	return flags
+}
+
+// sandboxCommand makes cmd start in a trampoline that applies the policy
+// before it runs the program. A cmd that is already a trampoline, as for
+// ulimit, gets the policy added to its settings.
+func (sh *Interpreter) sandboxCommand(cmd *exec.Cmd) error {
+	if !trampolineReady {
+		return errNoTrampoline
+	}
+	p := sh.sandbox
+	if p == nil {
+		p = defaultSandbox()
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if cmd.Path == self && len(cmd.Args) > 1 && cmd.Args[1] == trampolineFlag {
+		cmd.Args = slices.Concat(cmd.Args[:2], p.specs(), cmd.Args[2:])
+	} else {
+		// A script without a #! line is as restricted as this shell
+		specs := append(p.specs(), shellSpec(sh.optionOn("restricted")))
+		cmd.Args = append(cmd.Args[:1:1], trampolineArgs(specs, cmd.Path, cmd.Args)...)
+		cmd.Path = self
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= p.cloneFlags()
+	if cmd.SysProcAttr.Cloneflags&syscall.CLONE_NEWUSER != 0 {
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		// What the trampoline needs for the mounts and lo, which it drops
+		// before the exec
+		cmd.SysProcAttr.AmbientCaps = []uintptr{unix.CAP_SYS_ADMIN, unix.CAP_NET_ADMIN}
+	}
+	return nil
+}
+
+// SandboxCommand shows the sandbox policy, loads one with -f FILE, or runs
+// a command in the sandbox whether or not set -o sandbox is on.
+func (sh *Interpreter) SandboxCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	i := 1
+flags:
+	for ; i < len(argv) && strings.HasPrefix(argv[i], "-"); i++ {
+		switch argv[i] {
+		case "-f":
+			if i+1 >= len(argv) {
+				fmt.Fprintln(errOut, "sandbox: -f: option requires an argument")
+				return 2
+			}
+			i++
+			p, err := sh.loadSandboxPolicy(argv[i])
+			if err != nil {
+				fmt.Fprintf(errOut, "sandbox: %s\n", err)
+				return 1
+			}
+			sh.sandbox = p
+		case "--":
+			i++
+			break flags
+		default:
+			fmt.Fprintf(errOut, "sandbox: %s: invalid option\n", argv[i])
+			return 2
+		}
+	}
+	if i >= len(argv) {
+		if len(argv) == 1 {
+			p := sh.sandbox
+			if p == nil {
+				p = defaultSandbox()
+			}
+			p.print(out)
+		}
+		return 0
+	}
+	o := sh.findOption("sandbox", false)
+	saved := o.on
+	o.on = true
+	defer func() { o.on = saved }()
+	return sh.Menu(argv[i], argv[i:], fds)
+}
+
+// The rest runs in the trampoline.
+
+// applyTmpfsSpec mounts an empty tmpfs on path. It refuses to when the
+// trampoline shares the shell's mount namespace, so the shell's /tmp
+// stays. When it can't tell, it is in a user namespace of its own, where
+// only a mount namespace of its own can be changed anyway.
+func applyTmpfsSpec(path string) error {
+	own, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return err
+	}
+	if parent, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", os.Getppid())); err == nil && parent == own {
+		return errors.New("sandbox: no private mount namespace")
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	if err := unix.Mount("tmpfs", path, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("sandbox: %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyLoopbackSpec brings up lo, which a new network namespace starts
+// with down.
+func applyLoopbackSpec() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("sandbox: lo: %w", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("sandbox: lo: %w", err)
+	}
+	return nil
+}
+
+// Landlock access rights, by what a policy directive allows.
+const (
+	landlockRead = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockFile = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+// applyLandlock restricts the calling thread, and so the program it execs,
+// to the paths in specs, given as "r:path" or "w:path", and to running
+// self. Paths that don't exist are left out.
+func applyLandlock(specs []string, self *os.File) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("sandbox: landlock: %w", errno)
+	}
+	// Every right the kernel knows about up to ABI 3, so that anything
+	// the policy doesn't allow is denied
+	handled := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	ruleset, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("sandbox: landlock: %w", errno)
+	}
+	defer unix.Close(int(ruleset))
+
+	allow := func(fd int, access uint64) error {
+		var st unix.Stat_t
+		if unix.Fstat(fd, &st) == nil && st.Mode&unix.S_IFMT != unix.S_IFDIR {
+			access &= landlockFile
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: access & handled, Parent_fd: int32(fd)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, ruleset, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	for _, spec := range specs {
+		mode, path, _ := strings.Cut(spec, ":")
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			continue
+		}
+		access := uint64(landlockRead)
+		if mode == "w" {
+			access = handled
+		}
+		err = allow(fd, access)
+		unix.Close(fd)
+		if err != nil {
+			return fmt.Errorf("sandbox: %s: %w", path, err)
+		}
+	}
+	// A script without a #! line is run by the shell, from inside
+	if self != nil {
+		if err := allow(int(self.Fd()), landlockRead); err != nil {
+			return fmt.Errorf("sandbox: %s: %w", self.Name(), err)
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("sandbox: landlock: %w", errno)
+	}
+	return nil
+}
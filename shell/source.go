@@ -0,0 +1,74 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceCommand runs the commands in a file in the current shell, with
+// any further arguments as the positional parameters while it runs. A
+// return in the file, outside any function, ends it early.
+func (sh *Interpreter) SourceCommand(argv []string, fds FdTable) int {
+	errOut := fds.writer(2)
+	if len(argv) < 2 {
+		fmt.Fprintf(errOut, "%s%s: filename argument required\n", sh.location(), argv[0])
+		return 2
+	}
+	path, ok := sh.findSourceFile(argv[1])
+	if !ok {
+		fmt.Fprintf(errOut, "%s%s: %s: file not found\n", sh.location(), argv[0], argv[1])
+		return 1
+	}
+	data, err := os.ReadFile(sh.resolve(path))
+	if err != nil {
+		fmt.Fprintf(errOut, "%s%s: %s: %s\n", sh.location(), argv[0], argv[1], err)
+		return 1
+	}
+
+	savedArgs, savedFds := sh.args, sh.fds
+	savedName, savedLine := sh.scriptName, sh.lineNo
+	if len(argv) > 2 {
+		sh.args = argv[2:]
+	}
+	sh.fds = fds.Clone()
+	sh.scriptName = argv[1]
+	sh.sourceDepth++
+	defer func() {
+		if len(argv) > 2 {
+			sh.args = savedArgs
+		}
+		sh.restoreFds(savedFds, fds)
+		sh.scriptName, sh.lineNo = savedName, savedLine
+		sh.sourceDepth--
+		sh.returning = false
+	}()
+	sh.lastStatus = 0
+	return sh.runSource(string(data))
+}
+
+// findSourceFile finds the file source reads. A name without a slash is
+// looked for in PATH, where it needn't be executable, when sourcepath is
+// on, and then in the current directory.
+func (sh *Interpreter) findSourceFile(name string) (string, bool) {
+	if !strings.Contains(name, "/") && sh.optionOn("sourcepath") {
+		if file, ok := sh.findInPath(name, false); ok {
+			return file, true
+		}
+	}
+	info, err := os.Stat(sh.resolve(name))
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	return filepath.Clean(name), true
+}
+
+// location starts an error message with where the command that is running
+// came from, when that is a script: "file: line n: ".
+func (sh *Interpreter) location() string {
+	if sh.scriptName == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s: line %d: ", sh.scriptName, sh.lineNo)
+}
@@ -0,0 +1,76 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// startup is what the command line says about the files a new shell reads
+// before its first command.
+type startup struct {
+	login     bool   // argv[0] starts with '-', or -l was given
+	norc      bool   // --norc
+	noprofile bool   // --noprofile
+	rcfile    string // --rcfile, read in place of the usual rc files
+}
+
+// files returns the startup files for a shell called name whose HOME is
+// home, in the order they are read. As in bash, a login shell reads the
+// profile files, and only an interactive shell that isn't a login shell
+// reads the rc files. The system files are the shell's own, named like the
+// rc files: /etc/profile is written for sh, and this shell can't read it.
+func (s startup) files(name, home string, interactive bool) []string {
+	if s.login {
+		if s.noprofile {
+			return nil
+		}
+		files := []string{"/etc/" + name + "_profile"}
+		if home != "" {
+			// The shell's own profile, or failing that the one every shell reads
+			for _, profile := range []string{"." + name + "_profile", ".profile"} {
+				if path := filepath.Join(home, profile); isFile(path) {
+					files = append(files, path)
+					break
+				}
+			}
+		}
+		return files
+	}
+	if !interactive || s.norc {
+		return nil
+	}
+	if s.rcfile != "" {
+		return []string{s.rcfile}
+	}
+	files := []string{"/etc/" + name + "rc"}
+	if home != "" {
+		files = append(files, filepath.Join(home, "."+name+"rc"))
+	}
+	return files
+}
+
+// load sources the startup files that exist into sh. It stops early if one
+// of them exits.
+func (s startup) load(sh *Interpreter, name string, interactive bool) {
+	for _, path := range s.files(name, sh.getVar("HOME"), interactive) {
+		if !isFile(path) {
+			continue
+		}
+		sh.SourceCommand([]string{".", path}, sh.fds)
+		if sh.exiting {
+			return
+		}
+	}
+}
+
+// shellBaseName is the name startup files are named after: the program's
+// name, without its directory or the '-' that marks a login shell.
+func shellBaseName(argv0 string) string {
+	return filepath.Base(strings.TrimPrefix(argv0, "-"))
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,120 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// commandNotFound runs when argv[0] isn't anywhere in PATH. A function named
+// command_not_found_handle gets the whole command line; without one, close
+// names are suggested, and with set -o correct a single close name may be
+// run instead.
+func (sh *Interpreter) commandNotFound(argv []string, env []string, fds FdTable) int {
+	if fn, ok := sh.functions["command_not_found_handle"]; ok && !sh.inNotFoundHandle {
+		sh.inNotFoundHandle = true
+		defer func() { sh.inNotFoundHandle = false }()
+		return sh.callFunction(fn, append([]string{fn.name}, argv...), fds)
+	}
+	candidates := sh.suggestCommands(argv[0])
+	if len(candidates) == 1 && sh.optionOn("correct") && ownsTerminal() {
+		out := fds.writer(2)
+		fmt.Fprintf(out, "correct '%s' to '%s'? [y/n] ", argv[0], candidates[0])
+		if confirm(out) {
+			argv = append([]string{candidates[0]}, argv[1:]...)
+			if isBuiltin(argv[0]) {
+				return sh.callBuiltin(argv, fds)
+			}
+			return sh.runExternal(argv, env, fds)
+		}
+	}
+	sh.reportNotFound(fds.writer(2), argv[0])
+	return 127
+}
+
+// reportNotFound says cmd wasn't found, along with any commands it may have been a typo for.
+func (sh *Interpreter) reportNotFound(out io.Writer, cmd string) {
+	sh.reportLookupFailure(out, cmd, 127)
+	switch candidates := sh.suggestCommands(cmd); len(candidates) {
+	case 0:
+	case 1:
+		fmt.Fprintf(out, "did you mean %s?\n", candidates[0])
+	default:
+		fmt.Fprintf(out, "did you mean one of: %s?\n", strings.Join(candidates, ", "))
+	}
+}
+
+// confirm reads a single y or n from the terminal.
+func confirm(out io.Writer) bool {
+	oldState, err := term.MakeRaw(ttyFd)
+	if err != nil {
+		return false
+	}
+	var buf [1]byte
+	n, _ := os.Stdin.Read(buf[:])
+	term.Restore(ttyFd, oldState)
+	yes := n == 1 && (buf[0] == 'y' || buf[0] == 'Y')
+	if yes {
+		fmt.Fprintln(out, "y")
+	} else {
+		fmt.Fprintln(out, "n")
+	}
+	return yes
+}
+
+// suggestCommands returns the known command names closest to name, if any
+// are close enough to be a likely typo. At most three are returned.
+func (sh *Interpreter) suggestCommands(name string) []string {
+	if sh.trie == nil || strings.Contains(name, "/") {
+		return nil
+	}
+	limit := 1
+	if len(name) > 4 {
+		limit = 2
+	}
+	best := limit
+	var names []string
+	for _, word := range sh.trie.AutoComplete("") {
+		d := editDistance(name, word)
+		if d > best {
+			continue
+		}
+		if d < best {
+			best, names = d, nil
+		}
+		names = append(names, word)
+	}
+	slices.Sort(names)
+	return names[:min(len(names), 3)]
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// the Levenshtein distance, with swapping two neighbours counting as one edit.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
@@ -0,0 +1,72 @@
+package shell
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// Job control is only on when the shell reads from a terminal.
+var jobControl bool
+var ttyFd int
+var shellPgid int
+var shellTermios *term.State
+
+// jobControlSignals would stop or kill an interactive shell, so they are
+// caught while job control is on.
+var jobControlSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGTSTP, syscall.SIGTTIN}
+
+// initJobControl puts the shell in its own process group, in the foreground
+// of its terminal, and stops the keyboard signals from killing it. They go
+// to the foreground job instead.
+func initJobControl() {
+	ttyFd = int(os.Stdin.Fd())
+	if !term.IsTerminal(ttyFd) {
+		return
+	}
+	// Caught rather than ignored, so that children get the default action back
+	signal.Notify(signalCh, jobControlSignals...)
+	unix.Setpgid(0, 0) // fails harmlessly when we already lead a session
+	shellPgid = unix.Getpgrp()
+	setForeground(shellPgid)
+	shellTermios, _ = term.GetState(ttyFd)
+	jobControl = true
+}
+
+// setForeground hands the terminal to pgid. SIGTTOU is blocked on this
+// thread while doing so, since the shell may itself be in the background.
+func setForeground(pgid int) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var set, old unix.Sigset_t
+	bits := uint(unsafe.Sizeof(set.Val[0])) * 8
+	sig := uint(syscall.SIGTTOU) - 1
+	set.Val[sig/bits] |= 1 << (sig % bits)
+	unix.PthreadSigmask(unix.SIG_BLOCK, &set, &old)
+	defer unix.PthreadSigmask(unix.SIG_SETMASK, &old, nil)
+	return unix.IoctlSetPointerInt(ttyFd, unix.TIOCSPGRP, pgid)
+}
+
+// reclaimTerminal takes the terminal back after a foreground job stops or
+// exits, along with the terminal modes the job may have changed.
+func reclaimTerminal() {
+	setForeground(shellPgid)
+	if shellTermios != nil {
+		term.Restore(ttyFd, shellTermios)
+	}
+}
+
+// ownsTerminal reports whether the shell is in the foreground of its
+// terminal, so that it may hand the terminal to a job.
+func ownsTerminal() bool {
+	if !jobControl {
+		return false
+	}
+	pgid, err := unix.IoctlGetInt(ttyFd, unix.TIOCGPGRP)
+	return err == nil && pgid == shellPgid
+}
@@ -0,0 +1,168 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// usageTotal adds up the resource usage of the processes a timed command ran.
+type usageTotal struct {
+	mu     sync.Mutex
+	user   time.Duration
+	sys    time.Duration
+	maxRSS int64 // kilobytes
+	nvcsw  int64 // voluntary context switches
+	nivcsw int64 // involuntary context switches
+}
+
+func (u *usageTotal) add(ru *syscall.Rusage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.user += time.Duration(ru.Utime.Nano())
+	u.sys += time.Duration(ru.Stime.Nano())
+	u.maxRSS = max(u.maxRSS, ru.Maxrss)
+	u.nvcsw += ru.Nvcsw
+	u.nivcsw += ru.Nivcsw
+}
+
+const defaultTimeFormat = "\nreal\t%3lR\nuser\t%3lU\nsys\t%3lS"
+const posixTimeFormat = "real %2R\nuser %2U\nsys %2S"
+
+// isTimed reports whether line starts with the time keyword, and returns
+// what it times and whether -p was given.
+func isTimed(line string) (string, bool, bool) {
+	rest, ok := strings.CutPrefix(line, "time")
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return line, false, false
+	}
+	rest = strings.TrimSpace(rest)
+	posix := false
+	for {
+		if r, ok := strings.CutPrefix(rest, "-p"); ok && (r == "" || r[0] == ' ' || r[0] == '\t') {
+			posix, rest = true, strings.TrimSpace(r)
+		} else if r, ok := strings.CutPrefix(rest, "--"); ok && (r == "" || r[0] == ' ' || r[0] == '\t') {
+			rest = strings.TrimSpace(r)
+			break
+		} else {
+			break
+		}
+	}
+	return rest, posix, true
+}
+
+// runTimed runs line and reports how long it took on the shell's stderr.
+// Builtins are measured by the shell's own usage, everything else by the
+// usage of the processes it started.
+func (sh *Interpreter) runTimed(line string, posix bool) int {
+	var before, after syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &before)
+	start := time.Now()
+	total := &usageTotal{}
+	saved := sh.timing
+	sh.timing = total
+	if line != "" {
+		sh.executeLine(line)
+	}
+	sh.timing = saved
+	real := time.Since(start)
+	syscall.Getrusage(syscall.RUSAGE_SELF, &after)
+
+	total.user += time.Duration(after.Utime.Nano() - before.Utime.Nano())
+	total.sys += time.Duration(after.Stime.Nano() - before.Stime.Nano())
+	total.nvcsw += after.Nvcsw - before.Nvcsw
+	total.nivcsw += after.Nivcsw - before.Nivcsw
+	if total.maxRSS == 0 {
+		total.maxRSS = after.Maxrss
+	}
+
+	format, ok := sh.lookupShellVar("TIMEFORMAT")
+	if !ok {
+		format = defaultTimeFormat
+	}
+	if posix {
+		format = posixTimeFormat
+	}
+	if format != "" {
+		fmt.Fprintln(sh.fds.writer(2), formatTimes(format, real, total))
+	}
+	return sh.lastStatus
+}
+
+// formatTimes expands a TIMEFORMAT string. Besides bash's %[p][l]R, U and S
+// and %P, it knows %M for the largest resident set size in kilobytes, and
+// %w and %c for voluntary and involuntary context switches, as GNU time
+// does. \n and \t stand for a newline and a tab.
+func formatTimes(format string, real time.Duration, u *usageTotal) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c == '\\' && i+1 < len(format) {
+			switch format[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case 't':
+				b.WriteByte('\t')
+				i++
+				continue
+			}
+		}
+		if c != '%' || i+1 >= len(format) {
+			b.WriteByte(c)
+			continue
+		}
+		spec := i
+		i++
+		precision, long := 3, false
+		if format[i] >= '0' && format[i] <= '9' {
+			precision = min(int(format[i]-'0'), 3)
+			i++
+		}
+		if i < len(format) && format[i] == 'l' {
+			long = true
+			i++
+		}
+		if i >= len(format) {
+			b.WriteString(format[spec:])
+			break
+		}
+		switch format[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'R':
+			b.WriteString(formatSeconds(real, precision, long))
+		case 'U':
+			b.WriteString(formatSeconds(u.user, precision, long))
+		case 'S':
+			b.WriteString(formatSeconds(u.sys, precision, long))
+		case 'P':
+			percent := 0.0
+			if real > 0 {
+				percent = float64(u.user+u.sys) / float64(real) * 100
+			}
+			fmt.Fprintf(&b, "%.2f", percent)
+		case 'M':
+			fmt.Fprintf(&b, "%d", u.maxRSS)
+		case 'w':
+			fmt.Fprintf(&b, "%d", u.nvcsw)
+		case 'c':
+			fmt.Fprintf(&b, "%d", u.nivcsw)
+		default:
+			b.WriteString(format[spec : i+1])
+		}
+	}
+	return b.String()
+}
+
+func formatSeconds(d time.Duration, precision int, long bool) string {
+	secs := d.Seconds()
+	if !long {
+		return fmt.Sprintf("%.*f", precision, secs)
+	}
+	minutes := int(secs / 60)
+	return fmt.Sprintf("%dm%.*fs", minutes, precision, secs-float64(minutes*60))
+}
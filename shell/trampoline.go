@@ -0,0 +1,172 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// trampolineFlag starts the shell as a trampoline: it sets itself up the way
+// the arguments before "--" say, then execs the program after it. This is
+// how a single command gets limits that the shell itself doesn't have.
+const trampolineFlag = "--trampoline"
+
+// trampolineReady is set once the program has shown that it can be started
+// as a trampoline, by calling Main or MaybeTrampoline.
+var trampolineReady bool
+
+// errNoTrampoline is why a program that embeds the shell without calling
+// MaybeTrampoline can't run what needs a trampoline.
+var errNoTrampoline = errors.New("the program running the shell doesn't call shell.MaybeTrampoline")
+
+// MaybeTrampoline lets a program that embeds the shell run commands under
+// ulimit and the sandbox, and scripts without a #! line. Those start a new
+// copy of the program, which must call MaybeTrampoline first thing in main,
+// before it does anything else: in that copy it never returns. In any other
+// process it returns at once.
+func MaybeTrampoline() {
+	trampolineReady = true
+	if len(os.Args) > 1 && os.Args[1] == trampolineFlag {
+		runTrampoline(os.Args[2:])
+	}
+}
+
+// shellSpec says which shell runs the program after "--" if it turns out to
+// be a script without a #! line: a new one, restricted when restricted is
+// set. Alone, it makes a trampoline run the file as a script straight away.
+// With other settings, the trampoline applies them and execs the program,
+// and only an ENOEXEC brings it back, alone, so that the settings hold for
+// every thread of the shell's process.
+func shellSpec(restricted bool) string {
+	if restricted {
+		return "shell=restricted"
+	}
+	return "shell"
+}
+
+// trampolineArgs returns the arguments that make a trampoline apply specs
+// and then run path with argv.
+func trampolineArgs(specs []string, path string, argv []string) []string {
+	args := append([]string{trampolineFlag}, specs...)
+	args = append(args, "--", path)
+	return append(args, argv...)
+}
+
+// runTrampoline never returns. A spec that can't be applied fails the
+// command with status 126, as exec itself would.
+func runTrampoline(args []string) {
+	// Landlock and no_new_privs hold for the thread that sets them, which
+	// must be the one that execs
+	runtime.LockOSThread()
+	// The shell itself runs scripts without a #! line, and may not be
+	// reachable by its path once the mounts are done
+	self, _ := os.Open("/proc/self/exe")
+	i := 0
+	for i < len(args) && args[i] != "--" {
+		i++
+	}
+	if i+2 >= len(args) {
+		fmt.Fprintln(os.Stderr, "trampoline: no command")
+		os.Exit(2)
+	}
+	path, argv := args[i+1], args[i+2:]
+	if specs := args[:i]; len(specs) == 1 && strings.HasPrefix(specs[0], "shell") {
+		shellArgs := []string{argv[0]}
+		if specs[0] == shellSpec(true) {
+			shellArgs = append(shellArgs, "-r")
+		}
+		os.Exit(Main(slices.Concat(shellArgs, []string{"--", path}, argv[1:])))
+	}
+	// Landlock goes last, since it would forbid the mounts
+	var landlock []string
+	script := shellSpec(false)
+	for _, spec := range args[:i] {
+		if rule, ok := strings.CutPrefix(spec, "landlock="); ok {
+			landlock = append(landlock, rule)
+			continue
+		}
+		if strings.HasPrefix(spec, "shell") {
+			script = spec
+			continue
+		}
+		if err := applySpec(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[i+2], err)
+			os.Exit(126)
+		}
+	}
+	if len(landlock) > 0 {
+		if err := applyLandlock(landlock, self); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[i+2], err)
+			os.Exit(126)
+		}
+	}
+	// Capabilities the trampoline was given for its own setup aren't the
+	// program's
+	unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0)
+	err := syscall.Exec(path, argv, os.Environ())
+	if err == syscall.ENOEXEC {
+		// No #! line, so it's a script for the shell, as in scriptCommand
+		if self != nil {
+			exe := fmt.Sprintf("/proc/self/fd/%d", self.Fd())
+			err = syscall.Exec(exe, slices.Concat([]string{argv[0]}, trampolineArgs([]string{script}, path, argv)), os.Environ())
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", argv[0], checkInterpreter(path, err))
+	os.Exit(126)
+}
+
+func applySpec(spec string) error {
+	kind, value, _ := strings.Cut(spec, "=")
+	switch kind {
+	case "rlimit":
+		return applyRlimitSpec(value)
+	case "tmpfs":
+		return applyTmpfsSpec(value)
+	case "loopback":
+		return applyLoopbackSpec()
+	}
+	return fmt.Errorf("unknown trampoline setting %q", spec)
+}
+
+// rlimitSpec encodes a limit change as "resource:soft:hard", with "-" for a
+// value that stays as it is.
+func rlimitSpec(resource int, soft, hard *uint64) string {
+	field := func(v *uint64) string {
+		if v == nil {
+			return "-"
+		}
+		return strconv.FormatUint(*v, 10)
+	}
+	return fmt.Sprintf("rlimit=%d:%s:%s", resource, field(soft), field(hard))
+}
+
+func applyRlimitSpec(value string) error {
+	fields := strings.Split(value, ":")
+	if len(fields) != 3 {
+		return fmt.Errorf("bad limit %q", value)
+	}
+	resource, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("bad limit %q", value)
+	}
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &lim); err != nil {
+		return err
+	}
+	for i, target := range []*uint64{&lim.Cur, &lim.Max} {
+		if fields[i+1] == "-" {
+			continue
+		}
+		if *target, err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+			return fmt.Errorf("bad limit %q", value)
+		}
+	}
+	return syscall.Setrlimit(resource, &lim)
+}
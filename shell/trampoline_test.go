@@ -0,0 +1,49 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The trampoline is a new copy of the test binary, so it has to get as far
+// as MaybeTrampoline.
+func TestMain(m *testing.M) {
+	MaybeTrampoline()
+	os.Exit(m.Run())
+}
+
+func TestTrampolineScriptRestricted(t *testing.T) {
+	dir := t.TempDir()
+	// No #! line, so the trampoline hands it to a new shell
+	if err := os.WriteFile(filepath.Join(dir, "rs"), []byte("cd /\necho reached $PWD\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join(dir, "policy")
+	if err := os.WriteFile(policy, []byte("read /\ntmp shared\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"rs", "ulimit -t 100 -- rs", "sandbox rs"} {
+		t.Run(line, func(t *testing.T) {
+			var out bytes.Buffer
+			i := New(WithStdio(nil, &out, &out), WithDir(dir), WithEnv([]string{"PATH=" + dir + ":/usr/bin:/bin"}))
+			p, err := i.loadSandboxPolicy(policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i.sandbox = p
+			i.restrict()
+			i.Run(context.Background(), line)
+			got := out.String()
+			if strings.HasPrefix(line, "sandbox") && !strings.Contains(got, "reached") {
+				t.Skipf("no sandbox here: %s", got)
+			}
+			if !strings.Contains(got, "cd: restricted") || !strings.Contains(got, "reached "+dir) {
+				t.Errorf("the script's shell isn't restricted: %q", got)
+			}
+		})
+	}
+}
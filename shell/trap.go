@@ -0,0 +1,253 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var pseudoSignals = []string{"EXIT", "ERR", "DEBUG"}
+
+// Signals the shell catches all arrive here and wait until the shell is
+// between commands.
+var signalCh = make(chan os.Signal, 16)
+
+// fatalSignals end the shell when nothing else handles them. They are
+// caught anyway while an EXIT trap is set, so that it gets to run.
+var fatalSignals = []syscall.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM}
+
+// trappedSignals are the real signals trap has changed so far.
+var trappedSignals = map[syscall.Signal]bool{}
+
+// parseSignal accepts a signal name, with or without SIG and in any case,
+// a signal number, or a pseudo signal. 0 is EXIT.
+func parseSignal(spec string) (string, syscall.Signal, bool) {
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n == 0 {
+			return "EXIT", 0, true
+		}
+		name = strings.TrimPrefix(unix.SignalName(syscall.Signal(n)), "SIG")
+		if name == "" {
+			return "", 0, false
+		}
+	}
+	if slices.Contains(pseudoSignals, name) {
+		return name, 0, true
+	}
+	sig := unix.SignalNum("SIG" + name)
+	return name, sig, sig != 0
+}
+
+func signalLabel(name string) string {
+	if slices.Contains(pseudoSignals, name) {
+		return name
+	}
+	return "SIG" + name
+}
+
+// syncSignals makes the process's signal dispositions match the traps.
+func (sh *Interpreter) syncSignals() {
+	handled := map[syscall.Signal]bool{}
+	for sig := range trappedSignals {
+		handled[sig] = true
+	}
+	for _, sig := range fatalSignals {
+		handled[sig] = true
+	}
+	if jobControl {
+		for _, sig := range jobControlSignals {
+			handled[sig.(syscall.Signal)] = true
+		}
+	}
+	_, exitTrap := sh.traps["EXIT"]
+	for sig := range handled {
+		action, trapped := sh.traps[strings.TrimPrefix(unix.SignalName(sig), "SIG")]
+		switch {
+		case trapped && action == "":
+			signal.Ignore(sig)
+		case trapped, jobControl && slices.Contains(jobControlSignals, os.Signal(sig)):
+			signal.Notify(signalCh, sig)
+		case exitTrap && slices.Contains(fatalSignals, sig):
+			signal.Notify(signalCh, sig)
+		default:
+			signal.Reset(sig)
+		}
+	}
+}
+
+// runTrap runs a trap's action in the shell. $? is left as it was.
+func (sh *Interpreter) runTrap(action string) {
+	status := sh.lastStatus
+	sh.inTrap++
+	sh.executeLine(action)
+	sh.inTrap--
+	sh.lastStatus = status
+}
+
+// beforeCommand runs the DEBUG trap before the pipeline line is executed.
+func (sh *Interpreter) beforeCommand(line string) {
+	if action := sh.traps["DEBUG"]; action != "" && sh.inTrap == 0 {
+		sh.setVar("BASH_COMMAND", line)
+		sh.runTrap(action)
+	}
+}
+
+// runErrTrap runs the ERR trap, for a pipeline that just failed.
+func (sh *Interpreter) runErrTrap() {
+	if action := sh.traps["ERR"]; action != "" && sh.inTrap == 0 {
+		sh.runTrap(action)
+	}
+}
+
+func (sh *Interpreter) runPendingTraps() {
+	if sh.inTrap > 0 {
+		return
+	}
+	for {
+		var sig syscall.Signal
+		select {
+		case s := <-signalCh:
+			sig = s.(syscall.Signal)
+		default:
+			return
+		}
+		action, trapped := sh.traps[strings.TrimPrefix(unix.SignalName(sig), "SIG")]
+		switch {
+		case trapped:
+			if action != "" {
+				sh.runTrap(action)
+			}
+		case jobControl && slices.Contains(jobControlSignals, os.Signal(sig)):
+			// Only caught so that it doesn't stop or kill the shell
+		default:
+			sh.dieFromSignal(sig)
+		}
+	}
+}
+
+// runExitTrap runs the EXIT trap, once.
+func (sh *Interpreter) runExitTrap() {
+	action, ok := sh.traps["EXIT"]
+	if !ok {
+		return
+	}
+	delete(sh.traps, "EXIT")
+	if action != "" {
+		sh.runTrap(action)
+	}
+}
+
+// dieFromSignal ends the shell the way sig would have, after the EXIT trap.
+func (sh *Interpreter) dieFromSignal(sig syscall.Signal) {
+	sh.runExitTrap()
+	signal.Reset(sig)
+	syscall.Kill(os.Getpid(), sig)
+	os.Exit(128 + int(sig))
+}
+
+func (sh *Interpreter) TrapCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	args := argv[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return sh.printTraps(out, errOut, nil)
+	}
+	switch args[0] {
+	case "-l":
+		listSignals(out)
+		return 0
+	case "-p":
+		return sh.printTraps(out, errOut, args[1:])
+	}
+
+	action, specs := args[0], args[1:]
+	reset := action == "-"
+	if len(specs) == 0 {
+		// A lone signal resets it, as in trap INT
+		if _, _, ok := parseSignal(action); ok {
+			reset, specs = true, args
+		} else {
+			fmt.Fprintln(errOut, "trap: usage: trap [-lp] [[action] signal_spec ...]")
+			return 2
+		}
+	}
+	status := 0
+	for _, spec := range specs {
+		name, sig, ok := parseSignal(spec)
+		if !ok {
+			fmt.Fprintf(errOut, "trap: %s: invalid signal specification\n", spec)
+			status = 1
+			continue
+		}
+		if reset {
+			delete(sh.traps, name)
+		} else {
+			sh.traps[name] = action
+		}
+		if sig != 0 && sh.standalone {
+			trappedSignals[sig] = true
+		}
+	}
+	// Signals are the process's, so an embedded shell only keeps its traps
+	// for EXIT, ERR and DEBUG and leaves the host's handlers alone
+	if sh.standalone {
+		sh.syncSignals()
+	}
+	return status
+}
+
+// printTraps shows the traps for names, or all of them, as commands that
+// would set them again. It fails if a spec isn't a signal.
+func (sh *Interpreter) printTraps(out, errOut io.Writer, specs []string) int {
+	var names []string
+	status := 0
+	if len(specs) == 0 {
+		for name := range sh.traps {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	for _, spec := range specs {
+		if name, _, ok := parseSignal(spec); ok {
+			names = append(names, name)
+		} else {
+			fmt.Fprintf(errOut, "trap: %s: invalid signal specification\n", spec)
+			status = 1
+		}
+	}
+	for _, name := range names {
+		if action, ok := sh.traps[name]; ok {
+			fmt.Fprintf(out, "trap -- '%s' %s\n", strings.ReplaceAll(action, "'", `'\''`), signalLabel(name))
+		}
+	}
+	return status
+}
+
+func listSignals(out io.Writer) {
+	col := 0
+	for n := 1; n < 65; n++ {
+		name := unix.SignalName(syscall.Signal(n))
+		if name == "" {
+			continue
+		}
+		col++
+		sep := "\t"
+		if col%5 == 0 {
+			sep = "\n"
+		}
+		fmt.Fprintf(out, "%2d) %s%s", n, name, sep)
+	}
+	if col%5 != 0 {
+		fmt.Fprintln(out)
+	}
+}
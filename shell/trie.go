@@ -1,4 +1,4 @@
-package main
+package shell
 
 
 type TrieNode struct {
@@ -0,0 +1,239 @@
+package shell
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type rlimitResource struct {
+	flag     byte
+	resource int
+	name     string
+	unit     string
+	scale    uint64 // bytes per unit the limit is given in
+}
+
+var rlimitResources = []rlimitResource{
+	{'c', unix.RLIMIT_CORE, "core file size", "blocks", 1024},
+	{'d', unix.RLIMIT_DATA, "data seg size", "kbytes", 1024},
+	{'f', unix.RLIMIT_FSIZE, "file size", "blocks", 1024},
+	{'n', unix.RLIMIT_NOFILE, "open files", "", 1},
+	{'s', unix.RLIMIT_STACK, "stack size", "kbytes", 1024},
+	{'t', unix.RLIMIT_CPU, "cpu time", "seconds", 1},
+	{'u', unix.RLIMIT_NPROC, "max user processes", "", 1},
+	{'v', unix.RLIMIT_AS, "virtual memory", "kbytes", 1024},
+}
+
+func findResource(flag byte) (rlimitResource, bool) {
+	for _, r := range rlimitResources {
+		if r.flag == flag {
+			return r, true
+		}
+	}
+	return rlimitResource{}, false
+}
+
+// UlimitCommand shows or changes the shell's resource limits, which every
+// command it starts inherits. With "-- command ...", only that command runs
+// with the new limits.
+func (sh *Interpreter) UlimitCommand(argv []string, fds FdTable) int {
+	out, errOut := fds.writer(1), fds.writer(2)
+	var soft, hard, all bool
+	var selected []rlimitResource
+	var value string
+	var command []string
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			command = argv[i+1:]
+			break
+		}
+		if len(arg) > 1 && arg[0] == '-' {
+			for _, c := range []byte(arg[1:]) {
+				switch c {
+				case 'S':
+					soft = true
+				case 'H':
+					hard = true
+				case 'a':
+					all = true
+				default:
+					r, ok := findResource(c)
+					if !ok {
+						fmt.Fprintf(errOut, "ulimit: -%c: invalid option\n", c)
+						return 2
+					}
+					selected = append(selected, r)
+				}
+			}
+			continue
+		}
+		if value != "" {
+			fmt.Fprintln(errOut, "ulimit: too many arguments")
+			return 2
+		}
+		value = arg
+	}
+	if all {
+		selected = rlimitResources
+	} else if len(selected) == 0 {
+		selected = []rlimitResource{rlimitResources[2]} // -f
+	}
+
+	if value == "" {
+		if len(command) > 0 {
+			fmt.Fprintln(errOut, "ulimit: a limit is needed to run a command with")
+			return 2
+		}
+		for _, r := range selected {
+			var lim syscall.Rlimit
+			if err := syscall.Getrlimit(r.resource, &lim); err != nil {
+				fmt.Fprintf(errOut, "ulimit: %s: cannot get limit: %s\n", r.name, err)
+				return 1
+			}
+			current := lim.Cur
+			if hard && !soft {
+				current = lim.Max
+			}
+			if len(selected) > 1 {
+				unit := fmt.Sprintf("(-%c)", r.flag)
+				if r.unit != "" {
+					unit = fmt.Sprintf("(%s, -%c)", r.unit, r.flag)
+				}
+				fmt.Fprintf(out, "%-20s %18s ", r.name, unit)
+			}
+			fmt.Fprintln(out, formatLimit(current, r.scale))
+		}
+		return 0
+	}
+	if all {
+		fmt.Fprintln(errOut, "ulimit: -a: a limit can't be given")
+		return 2
+	}
+	if !soft && !hard {
+		soft, hard = true, true
+	}
+
+	var specs []string
+	for _, r := range selected {
+		var lim syscall.Rlimit
+		if err := syscall.Getrlimit(r.resource, &lim); err != nil {
+			fmt.Fprintf(errOut, "ulimit: %s: cannot get limit: %s\n", r.name, err)
+			return 1
+		}
+		n, err := parseLimit(value, r.scale, lim)
+		if err != nil {
+			fmt.Fprintf(errOut, "ulimit: %s: %s\n", value, err)
+			return 1
+		}
+		var newSoft, newHard *uint64
+		if soft {
+			newSoft, lim.Cur = &n, n
+		}
+		if hard {
+			newHard, lim.Max = &n, n
+		}
+		if len(command) > 0 {
+			specs = append(specs, rlimitSpec(r.resource, newSoft, newHard))
+			continue
+		}
+		// The limits are the process's, which an embedded shell doesn't own
+		if !sh.standalone {
+			fmt.Fprintf(errOut, "ulimit: %s: cannot modify limit: not the shell's process\n", r.name)
+			return 1
+		}
+		if err := syscall.Setrlimit(r.resource, &lim); err != nil {
+			fmt.Fprintf(errOut, "ulimit: %s: cannot modify limit: %s\n", r.name, err)
+			return 1
+		}
+	}
+	if len(command) == 0 {
+		return 0
+	}
+	return sh.runLimited(specs, command, fds)
+}
+
+// runLimited runs argv through a trampoline that applies specs first.
+func (sh *Interpreter) runLimited(specs []string, argv []string, fds FdTable) int {
+	filePath, status := sh.lookupCommand(argv[0])
+	if status != 0 {
+		sh.reportLookupFailure(fds.writer(2), argv[0], status)
+		return status
+	}
+	self, err := os.Executable()
+	if err == nil && !trampolineReady {
+		err = errNoTrampoline
+	}
+	if err != nil {
+		fmt.Fprintf(fds.writer(2), "%s: %s\n", argv[0], err)
+		return 126
+	}
+	env := append(sh.exportedEnv(), "_="+filePath)
+	// A script without a #! line is as restricted as this shell
+	specs = append(specs, shellSpec(sh.optionOn("restricted")))
+	return sh.runProgram(self, append([]string{argv[0]}, trampolineArgs(specs, filePath, argv)...), env, fds)
+}
+
+// pinFileLimit puts back the open files limit the shell inherited. Go
+// raises the soft limit for itself and lowers it again in every child it
+// starts, so without this ulimit -n shows a limit no command gets. The
+// inherited value only survives in a new child, so one is started stopped
+// at its exec and read before it runs.
+func pinFileLimit() {
+	var cur syscall.Rlimit
+	if err := syscall.Getrlimit(unix.RLIMIT_NOFILE, &cur); err != nil || cur.Max == 0 || cur.Cur != cur.Max-1 {
+		return // Go left it alone
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return
+	}
+	runtime.LockOSThread() // a traced child belongs to the thread that started it
+	defer runtime.UnlockOSThread()
+	p, err := os.StartProcess(self, []string{self}, &os.ProcAttr{Sys: &syscall.SysProcAttr{Ptrace: true}})
+	if err != nil {
+		return
+	}
+	var orig unix.Rlimit
+	err = unix.Prlimit(p.Pid, unix.RLIMIT_NOFILE, nil, &orig)
+	p.Kill()
+	p.Wait()
+	if err == nil && orig.Cur < cur.Cur {
+		// This also stops Go changing it for children
+		syscall.Setrlimit(unix.RLIMIT_NOFILE, &syscall.Rlimit{Cur: orig.Cur, Max: cur.Max})
+	}
+}
+
+func formatLimit(v, scale uint64) string {
+	if v == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v/scale, 10)
+}
+
+// parseLimit reads a limit given in the resource's units, or one of the
+// words unlimited, soft and hard.
+func parseLimit(value string, scale uint64, current syscall.Rlimit) (uint64, error) {
+	switch value {
+	case "unlimited":
+		return unix.RLIM_INFINITY, nil
+	case "soft":
+		return current.Cur, nil
+	case "hard":
+		return current.Max, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number")
+	}
+	if n > math.MaxUint64/scale {
+		return 0, fmt.Errorf("limit out of range")
+	}
+	return n * scale, nil
+}
@@ -0,0 +1,905 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func isBuiltin(cmd string) bool {
+	for _, b := range builtIns {
+		if b == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlePipe runs a pipeline and returns its exit status. Stages report
+// their own errors; with pipefail the stage that failed is named as well.
+func (sh *Interpreter) HandlePipe(input string) int {
+	cmds := sh.parsePipeline(input)
+	if err := sh.expansionError(); err != nil {
+		return sh.failExpansion(err)
+	}
+	if len(cmds) < 2 {
+		return 0 // Not a pipeline
+	}
+	statuses := sh.executeNPipeline(cmds, input)
+	sh.pipeStatus = statuses
+	status, failed := sh.pipelineStatus(statuses)
+	if sh.optionOn("pipefail") && status != 0 && failed < len(cmds) {
+		fmt.Fprintf(sh.fds.writer(2), "pipefail: stage %d of %d (%s) exited with status %d\n",
+			failed+1, len(cmds), strings.Join(wordTexts(cmds[failed]), " "), status)
+	}
+	return status
+}
+
+// pipelineStatus returns the exit status of the last stage or, with
+// pipefail, of the rightmost stage that failed, along with that stage's index.
+func (sh *Interpreter) pipelineStatus(statuses []int) (int, int) {
+	last := len(statuses) - 1
+	if sh.optionOn("pipefail") {
+		for i := last; i >= 0; i-- {
+			if statuses[i] != 0 {
+				return statuses[i], i
+			}
+		}
+	}
+	return statuses[last], last
+}
+
+// parsePipeline splits input into the words of each pipeline stage.
+func (sh *Interpreter) parsePipeline(input string) [][]word {
+	// Split input into N commands, respecting quoting
+	cmdStrs := splitPipelineWithQuoting(input)
+	cmds := make([][]word, len(cmdStrs))
+	for i, s := range cmdStrs {
+		// "a |& b" splits into "a " and "& b"; it is shorthand for "a 2>&1 | b"
+		if i > 0 && strings.HasPrefix(s, "&") {
+			s = s[1:]
+			cmds[i-1] = append(cmds[i-1], typedWord("2>&1"))
+		}
+		cmds[i] = sh.splitWords(strings.TrimSpace(s))
+	}
+	return cmds
+}
+
+// Split a pipeline string into command segments, respecting quotes
+func splitPipelineWithQuoting(input string) []string {
+	var result []string
+	var current strings.Builder
+	inSingle, inDouble, escaped := false, false, false
+	prev := rune(0)
+	for _, c := range input {
+		quoted := escaped || inSingle || inDouble
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingle:
+			escaped = true
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+		case c == '|' && !quoted && prev != '>':
+			// ">|" is a redirection, not a pipe
+			result = append(result, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(c)
+		prev = c
+		if quoted {
+			prev = 0 // only a plain > makes >| a redirection
+		}
+	}
+	if current.Len() > 0 {
+		result = append(result, current.String())
+	}
+	return result
+}
+
+// pipeReader and pipeWriter let io.Pipe ends sit in an FdTable. They are
+// only used next to builtin stages.
+type pipeReader struct{ *io.PipeReader }
+
+func (pipeReader) Write([]byte) (int, error) { return 0, errBadFd }
+
+type pipeWriter struct{ *io.PipeWriter }
+
+func (pipeWriter) Read([]byte) (int, error) { return 0, errBadFd }
+
+type stageResult struct {
+	index  int
+	status int
+}
+
+// runningPipeline is a pipeline whose stages have all been started.
+type runningPipeline struct {
+	sh         *Interpreter // the shell that started it
+	pids       []int        // external stages only
+	pgid       int          // process group shared by the external stages
+	foreground bool
+	stopped    map[int]bool // guarded by jobsMu
+	results    chan stageResult
+	n          int
+	usage      *usageTotal // where a timed pipeline reports its usage
+}
+
+func (sh *Interpreter) newPipeline(n int, foreground bool) *runningPipeline {
+	return &runningPipeline{
+		sh:         sh,
+		foreground: foreground,
+		stopped:    map[int]bool{},
+		results:    make(chan stageResult, n),
+		n:          n,
+		usage:      sh.timing,
+	}
+}
+
+// Generalized N-length pipeline executor. Runs the pipeline in the foreground
+// and returns the exit status of every stage.
+func (sh *Interpreter) executeNPipeline(cmds [][]word, cmdline string) []int {
+	j := sh.newJob(cmdline, sh.startPipeline(cmds, sh.fds, true))
+	if !sh.runForeground(j) {
+		return []int{128 + int(syscall.SIGTSTP)}
+	}
+	return j.statuses
+}
+
+// stageInShell reports whether a stage's command, after any leading
+// redirections and exec, is a function or a builtin, which run inside the
+// shell.
+func (sh *Interpreter) stageInShell(argv []word) bool {
+	for i := 0; i < len(argv); i++ {
+		if r, ok := redirectWord(argv[i]); ok {
+			if r.target == "" {
+				i++ // skip filename
+			}
+			continue
+		}
+		if argv[i].text == "exec" {
+			continue
+		}
+		_, isFunc := sh.functions[argv[i].text]
+		return isFunc || isBuiltin(argv[i].text)
+	}
+	return false
+}
+
+// closeEnd closes the shell's side of a pipe between two stages.
+func closeEnd(end io.ReadWriter) {
+	if c, ok := end.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// startPipeline starts every stage of a pipeline on top of base. Each stage
+// applies its own redirections on top of the pipe ends it is connected to.
+// External stages are started before it returns, so their pids are known.
+//
+// Two external stages are joined by a kernel pipe, so data never passes
+// through the shell and a reader that exits sends SIGPIPE to the writer.
+// Only a function or builtin stage, which runs in a goroutine on a copy of
+// the shell, gets an io.Pipe.
+func (sh *Interpreter) startPipeline(cmds [][]word, base FdTable, foreground bool) *runningPipeline {
+	n := len(cmds)
+	readEnds := make([]io.ReadWriter, n-1)  // what stage i+1 reads
+	writeEnds := make([]io.ReadWriter, n-1) // what stage i writes
+	for i := 0; i < n-1; i++ {
+		if !sh.stageInShell(cmds[i]) && !sh.stageInShell(cmds[i+1]) {
+			if r, w, err := os.Pipe(); err == nil {
+				readEnds[i], writeEnds[i] = r, w
+				continue
+			}
+		}
+		r, w := io.Pipe()
+		readEnds[i], writeEnds[i] = pipeReader{r}, pipeWriter{w}
+	}
+	p := sh.newPipeline(n, foreground)
+
+	// release drops the shell's copies of stage i's pipe ends
+	release := func(i int) {
+		if i != n-1 {
+			closeEnd(writeEnds[i])
+		}
+		// Nothing reads our input any more, so unblock the stage feeding it
+		if i > 0 {
+			closeEnd(readEnds[i-1])
+		}
+	}
+	// finish releases what stage i held and reports how it ended
+	finish := func(i int, opened []*os.File, res stageResult) {
+		closeFiles(opened)
+		release(i)
+		p.results <- res
+	}
+
+	for i := 0; i < n; i++ {
+		fds := base.Clone()
+		var piped []int
+		if i > 0 {
+			fds[0] = readEnds[i-1]
+			piped = append(piped, 0)
+		}
+		if i < n-1 {
+			fds[1] = writeEnds[i]
+			piped = append(piped, 1)
+		}
+		cmdArgs, fds, opened, err := sh.applyRedirects(cmds[i], fds, piped)
+		if err != nil {
+			fmt.Fprintln(base.writer(2), err)
+			finish(i, nil, stageResult{i, 1})
+			continue
+		}
+		sh.traceCommand(cmdArgs)
+		if err := sh.restrictCommand(cmdArgs); err != nil {
+			fmt.Fprintln(fds.writer(2), err)
+			finish(i, opened, stageResult{i, 1})
+			continue
+		}
+		// A pipeline stage is its own subshell, so exec just runs the command
+		if len(cmdArgs) > 0 && cmdArgs[0] == "exec" {
+			cmdArgs = cmdArgs[1:]
+		}
+		assigns, cmdArgs := splitAssignments(cmdArgs)
+		if len(cmdArgs) == 0 {
+			finish(i, opened, stageResult{i, 0})
+			continue
+		}
+		// Functions, builtins and command_not_found_handle run on a copy of
+		// the shell, with the assignments exported there as they would be in
+		// a subshell
+		_, isFunc := sh.functions[cmdArgs[0]]
+		filePath, status := "", 0
+		if !isFunc && !isBuiltin(cmdArgs[0]) {
+			filePath, status = sh.lookupCommand(cmdArgs[0])
+		}
+		notFound := status == 127 && !strings.Contains(cmdArgs[0], "/")
+		if isFunc || isBuiltin(cmdArgs[0]) || notFound {
+			sub := sh.subshell()
+			go func(i int, cmdArgs, assigns []string, fds FdTable, opened []*os.File) {
+				res := stageResult{i, sub.withAssignments(assigns, func() int {
+					if notFound {
+						return sub.commandNotFound(cmdArgs, sub.exportedEnv(), fds)
+					}
+					return sub.Menu(cmdArgs[0], cmdArgs, fds)
+				})}
+				finish(i, opened, res)
+			}(i, cmdArgs, assigns, fds, opened)
+			continue
+		}
+		if status != 0 {
+			sh.reportLookupFailure(fds.writer(2), cmdArgs[0], status)
+			finish(i, opened, stageResult{i, status})
+			continue
+		}
+		cmd := exec.Command(filePath, cmdArgs[1:]...)
+		cmd.Args[0] = cmdArgs[0] // report errors under the name that was typed
+		cmd.Env = append(sh.exportedEnv(assigns...), "_="+filePath)
+		cmd.Dir = sh.dir
+		fds.attach(cmd, sh.coprocFd)
+		done := func(i int, opened []*os.File) func(stageResult) {
+			return func(res stageResult) { finish(i, opened, res) }
+		}(i, opened)
+		if err := p.startExternal(i, cmd, done); err != nil {
+			fmt.Fprintf(fds.writer(2), "%s: %s\n", cmdArgs[0], err)
+			done(stageResult{i, exitStatus(err)})
+			continue
+		}
+		// The child has its own copies of kernel pipe ends now. Ours must go,
+		// or the reader would never see EOF. With a fan on one of its
+		// streams, Go copies through ours until it exits, and release
+		// closes them then.
+		if isFan(cmd.Stdin) || isFan(cmd.Stdout) || isFan(cmd.Stderr) {
+			continue
+		}
+		if i != n-1 {
+			if f, ok := writeEnds[i].(*os.File); ok {
+				f.Close()
+			}
+		}
+		if i > 0 {
+			if f, ok := readEnds[i-1].(*os.File); ok {
+				f.Close()
+			}
+		}
+	}
+	return p
+}
+
+// startExternal starts cmd as stage i in the pipeline's process group and
+// calls done once it has exited. With job control, the first process started
+// for a foreground pipeline also takes over the terminal.
+func (p *runningPipeline) startExternal(i int, cmd *exec.Cmd, done func(stageResult)) error {
+	if jobControl {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: p.pgid}
+		if p.foreground && p.pgid == 0 {
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = ttyFd
+		}
+	}
+	sh := p.sh
+	if sh.optionOn("sandbox") {
+		if err := sh.sandboxCommand(cmd); err != nil {
+			return err
+		}
+	}
+	err := cmd.Start()
+	if errors.Is(err, syscall.ENOEXEC) {
+		// Not a binary and no #! line: POSIX says it's a shell script
+		if cmd, err = sh.scriptCommand(cmd); err == nil {
+			err = cmd.Start()
+		}
+	}
+	if err != nil {
+		return checkInterpreter(sh.resolve(cmd.Path), err)
+	}
+	pid := cmd.Process.Pid
+	if p.pgid == 0 {
+		p.pgid = pid
+	}
+	p.pids = append(p.pids, pid)
+	sh.track(cmd.Process, true)
+	go func() {
+		if jobControl {
+			p.watchStops(pid)
+		}
+		err := cmd.Wait()
+		sh.track(cmd.Process, false)
+		if p.usage != nil && cmd.ProcessState != nil {
+			if ru, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+				p.usage.add(ru)
+			}
+		}
+		status := exitStatus(err)
+		if errors.Is(err, io.ErrClosedPipe) {
+			status = 0 // the next stage stopped reading, like SIGPIPE
+		}
+		done(stageResult{i, status})
+	}()
+	return nil
+}
+
+// scriptCommand runs the file cmd would have executed in a new copy of the
+// shell, with the same arguments, environment and descriptors. The script's
+// shell is as restricted as this one.
+func (sh *Interpreter) scriptCommand(cmd *exec.Cmd) (*exec.Cmd, error) {
+	if !trampolineReady {
+		return nil, errNoTrampoline
+	}
+	self, err := os.Executable()
+	if err != nil {
+		self = "/proc/self/exe"
+	}
+	script := exec.Command(self)
+	script.Args = slices.Concat(cmd.Args[:1], trampolineArgs([]string{shellSpec(sh.optionOn("restricted"))}, cmd.Path, cmd.Args))
+	script.Env = cmd.Env
+	script.Dir = cmd.Dir
+	script.Stdin, script.Stdout, script.Stderr = cmd.Stdin, cmd.Stdout, cmd.Stderr
+	script.ExtraFiles = cmd.ExtraFiles
+	script.SysProcAttr = cmd.SysProcAttr
+	return script, nil
+}
+
+// si_code values of SIGCHLD, which x/sys/unix doesn't define
+const (
+	cldTrapped   = 4
+	cldStopped   = 5
+	cldContinued = 6
+)
+
+// watchStops follows pid through stops and continues until it exits. The
+// exit itself is left for cmd.Wait to collect.
+func (p *runningPipeline) watchStops(pid int) {
+	for {
+		var info unix.Siginfo
+		err := unix.Waitid(unix.P_PID, pid, &info, unix.WEXITED|unix.WSTOPPED|unix.WCONTINUED|unix.WNOWAIT, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		var stopped bool
+		switch info.Code {
+		case cldStopped, cldTrapped:
+			stopped = true
+			unix.Waitid(unix.P_PID, pid, &info, unix.WSTOPPED|unix.WNOHANG, nil)
+		case cldContinued:
+			unix.Waitid(unix.P_PID, pid, &info, unix.WCONTINUED|unix.WNOHANG, nil)
+		default:
+			return
+		}
+		jobsMu.Lock()
+		p.stopped[pid] = stopped
+		jobsCond.Broadcast()
+		jobsMu.Unlock()
+	}
+}
+
+// wait blocks until every stage has finished and returns their exit statuses.
+func (p *runningPipeline) wait() []int {
+	statuses := make([]int, p.n)
+	for i := 0; i < p.n; i++ {
+		res := <-p.results
+		statuses[res.index] = res.status
+	}
+	return statuses
+}
+
+// Pipeline handler that supports builtins on both sides
+func (sh *Interpreter) executePipelineBuiltinAware(cmd1Args []string, cmd2Args []string) error {
+	r, w := io.Pipe()
+
+	// Left side
+	leftIsBuiltin := isBuiltin(cmd1Args[0])
+	// Right side
+	rightIsBuiltin := isBuiltin(cmd2Args[0])
+
+	errChan := make(chan error, 2)
+
+	// LEFT
+	go func() {
+		if leftIsBuiltin {
+			// Call builtin with w as output
+			sh.callBuiltin(cmd1Args, FdTable{0: os.Stdin, 1: pipeWriter{w}, 2: os.Stderr})
+			w.Close()
+			errChan <- nil
+		} else {
+			filePath1, exists1 := sh.findBinInPath(cmd1Args[0])
+			if !exists1 {
+				w.Close()
+				errChan <- fmt.Errorf("%s: command not found", cmd1Args[0])
+				return
+			}
+			cmd1 := exec.Command(filePath1, cmd1Args[1:]...)
+			cmd1.Stdout = w
+			cmd1.Stderr = os.Stderr
+			cmd1.Stdin = os.Stdin
+			err := cmd1.Run()
+			w.Close()
+			errChan <- err
+		}
+	}()
+
+	// RIGHT
+	if rightIsBuiltin {
+		sh.callBuiltin(cmd2Args, FdTable{0: pipeReader{r}, 1: os.Stdout, 2: os.Stderr})
+		io.Copy(io.Discard, r) // Drain the pipe to avoid deadlock
+		return <-errChan
+	} else {
+		filePath2, exists2 := sh.findBinInPath(cmd2Args[0])
+		if !exists2 {
+			return fmt.Errorf("%s: command not found", cmd2Args[0])
+		}
+		cmd2 := exec.Command(filePath2, cmd2Args[1:]...)
+		cmd2.Stdin = r
+		cmd2.Stdout = os.Stdout
+		cmd2.Stderr = os.Stderr
+		err := cmd2.Run()
+		return err
+	}
+}
+
+// Helper to call a builtin by name and argv. Returns the builtin's exit status.
+func (sh *Interpreter) callBuiltin(argv []string, fds FdTable) int {
+	in, out := fds.reader(0), fds.writer(1)
+	switch argv[0] {
+	case "exit":
+		return sh.ExitCommand(argv, in, out)
+	case "echo":
+		return EchoCommand(argv, in, out)
+	case "type":
+		return sh.TypeCommand(argv, in, out)
+	case "pwd":
+		return sh.getCurrentDir(argv, in, out)
+	case "cd":
+		return sh.changeDir(argv, in, out)
+	case "history":
+		if len(argv) > 2 {
+			// The history file is named relative to the shell's directory
+			argv = append(argv[:2:2], sh.resolve(argv[2]))
+		}
+		return HistoryCommand(argv, in, out, sh.hist)
+	case "set":
+		return sh.SetCommand(argv, fds)
+	case "jobs":
+		return sh.JobsCommand(argv, fds)
+	case "fg":
+		return sh.FgCommand(argv, fds)
+	case "bg":
+		return sh.BgCommand(argv, fds)
+	case "wait":
+		return sh.WaitCommand(argv, fds)
+	case "disown":
+		return sh.DisownCommand(argv, fds)
+	case "export":
+		return sh.ExportCommand(argv, fds)
+	case "unset":
+		return sh.UnsetCommand(argv, fds)
+	case "env":
+		return sh.EnvCommand(argv, fds)
+	case "hash":
+		return sh.HashCommand(argv, fds)
+	case "return":
+		return sh.ReturnCommand(argv, fds)
+	case "ulimit":
+		return sh.UlimitCommand(argv, fds)
+	case "trap":
+		return sh.TrapCommand(argv, fds)
+	case "shopt":
+		return sh.ShoptCommand(argv, fds)
+	case "read":
+		return sh.ReadCommand(argv, fds)
+	case "source", ".":
+		return sh.SourceCommand(argv, fds)
+	case "break", "continue":
+		return sh.BreakCommand(argv, fds)
+	case "sandbox":
+		return sh.SandboxCommand(argv, fds)
+	}
+	return 0
+}
+
+// ExitCommand makes the interpreter stop once the commands that are running
+// unwind. Whoever started them runs the EXIT trap and exits.
+func (sh *Interpreter) ExitCommand(argv []string, in io.Reader, out io.Writer) int {
+	code := sh.lastStatus
+	if len(argv) > 1 {
+		argCode, err := strconv.Atoi(argv[1])
+		if err != nil {
+			fmt.Fprintf(out, "exit: %s: numeric argument required\n", argv[1])
+			argCode = 2
+		}
+		code = argCode
+	}
+	sh.exiting, sh.exitStatus = true, code
+	return code
+}
+
+// ExecCommand replaces the shell with argv[1:], or with no command makes
+// the redirections in fds permanent. It takes ownership of opened. An
+// interpreter embedded in another program runs the command and exits with
+// its status instead, as the program can't be replaced.
+func (sh *Interpreter) ExecCommand(argv []string, fds FdTable, opened []*os.File) int {
+	if len(argv) < 2 {
+		sh.adoptFds(fds, opened)
+		return 0
+	}
+	defer closeFiles(opened)
+	filePath, status := sh.lookupCommand(argv[1])
+	if status != 0 {
+		sh.reportLookupFailure(fds.writer(2), "exec: "+argv[1], status)
+		return status
+	}
+	// A sandboxed program needs a trampoline, so it can't replace the shell
+	if !sh.standalone || sh.optionOn("sandbox") {
+		status = sh.runProgram(filePath, argv[1:], append(sh.exportedEnv(), "_="+filePath), fds)
+		sh.exiting, sh.exitStatus = true, status
+		return status
+	}
+	sh.saveHistory()
+	if err := installFds(fds); err != nil {
+		fmt.Fprintf(os.Stderr, "exec: %s\n", err)
+		os.Exit(1)
+	}
+	env := append(sh.exportedEnv(), "_="+filePath)
+	err := syscall.Exec(filePath, argv[1:], env)
+	if err == syscall.ENOEXEC {
+		if self, selfErr := os.Executable(); selfErr == nil {
+			err = syscall.Exec(self, append([]string{argv[1], filePath}, argv[2:]...), env)
+		}
+	}
+	// The descriptors are already rewired, so there is no going back
+	fmt.Fprintf(os.Stderr, "exec: %s: %s\n", argv[1], err)
+	os.Exit(126)
+	return 126
+}
+
+func EchoCommand(argv []string, in io.Reader, out io.Writer) int {
+	if len(argv) < 2 {
+		fmt.Fprintln(out, "")
+		return 0
+	}
+	output := strings.Join(argv[1:], " ")
+	if _, err := fmt.Fprintf(out, "%s\n", output); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// keywords are the reserved words the parser gives a meaning to.
+var keywords = []string{"time", "if", "then", "elif", "else", "fi", "while", "until", "do", "done", "!", "{", "}", "function", "coproc"}
+
+func (sh *Interpreter) TypeCommand(argv []string, in io.Reader, out io.Writer) int {
+	if len(argv) == 1 {
+		return 0
+	}
+	value := argv[1]
+	if slices.Contains(keywords, value) {
+		fmt.Fprintf(out, "%s is a shell keyword\n", value)
+		return 0
+	}
+	if fn, ok := sh.functions[value]; ok {
+		fmt.Fprintf(out, "%s is a function\n%s\n", value, fn.text)
+		return 0
+	}
+	if slices.Contains(builtIns, value) {
+		fmt.Fprintf(out, "%s is a shell builtin\n", value)
+		return 0
+	}
+	if strings.Contains(value, "/") {
+		if _, status := sh.lookupCommand(value); status == 0 {
+			fmt.Fprintf(out, "%s is %s\n", value, value)
+			return 0
+		}
+	} else if file, ok := sh.hashedCommand(value); ok {
+		fmt.Fprintf(out, "%s is hashed (%s)\n", value, file)
+		return 0
+	}
+	if file, exists := sh.findBinInPath(value); exists {
+		fmt.Fprintf(out, "%s is %s\n", value, file)
+		return 0
+	}
+	fmt.Fprintf(out, "%s: not found\n", value)
+	return 1
+}
+
+func (sh *Interpreter) getCurrentDir(argv []string, in io.Reader, out io.Writer) int {
+	fmt.Fprintf(out, "%s\n", sh.dir)
+	return 0
+}
+
+func (sh *Interpreter) changeDir(argv []string, in io.Reader, out io.Writer) int {
+	if len(argv) < 2 {
+		argv = []string{"cd", sh.getVar("HOME")} // Default to HOME if no argument is provided
+	}
+	path := argv[1]
+	if path == "~" || path == "$HOME" {
+		path = sh.getVar("HOME")
+	}
+	announce := path == "-"
+	if announce {
+		oldPwd, ok := sh.lookupShellVar("OLDPWD")
+		if !ok {
+			fmt.Fprintln(out, "cd: OLDPWD not set")
+			return 1
+		}
+		path = oldPwd
+	}
+	dir, err := filepath.EvalSymlinks(sh.resolve(path))
+	if err == nil && !isDir(dir) {
+		err = syscall.ENOTDIR
+	}
+	if err == nil {
+		err = unix.Access(dir, unix.X_OK)
+	}
+	// Only the shell that is the process moves the process with it
+	if err == nil && sh.standalone {
+		err = os.Chdir(dir)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "cd: %s: No such file or directory\n", path)
+		return 1
+	}
+	sh.dir = dir
+	if oldPwd, ok := sh.lookupShellVar("PWD"); ok {
+		sh.setVar("OLDPWD", oldPwd)
+	}
+	sh.setVar("PWD", dir)
+	if announce {
+		fmt.Fprintln(out, sh.getVar("PWD"))
+	}
+	return 0
+}
+
+func HistoryCommand(argv []string, in io.Reader, out io.Writer, hist *History) int {
+	cnt := 0
+	if len(argv) > 1 {
+		argCode, err := strconv.Atoi(argv[1])
+		if err == nil {
+			cnt = argCode
+		}
+	}
+	if len(argv) > 2 {
+		if argv[1] == "-r" {
+			if _, err := os.Stat(argv[2]); err == nil && !os.IsNotExist(err) {
+				file, err := os.ReadFile(argv[2])
+				if err != nil {
+					fmt.Fprintf(out, "Error reading history file: %s\n", err)
+					return 1
+				}
+				lines := strings.Split(string(file), "\n")
+				for _, line := range lines {
+					if line != "" {
+						hist.Add(line)
+					}
+				}
+				return 0
+			} else {
+				return 1
+			}
+		}
+		if argv[1] == "-w" {
+			if len(argv) < 3 {
+				fmt.Fprintln(out, "Usage: history -w <filename>")
+				return 2
+			}
+			file, err := os.Create(argv[2])
+			if err != nil {
+				return 1
+			}
+			defer file.Close()
+			for i := 1; i <= hist.Len(); i++ {
+				if command, exists := hist.Get(i - 1); exists {
+					fmt.Fprintf(file, "%s\n", command)
+				}
+			}
+			return 0
+		}
+		if argv[1] == "-a" {
+			if len(argv) < 3 {
+				fmt.Fprintln(out, "Usage: history -a <filename>")
+				return 2
+			}
+			file, err := os.OpenFile(argv[2], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				fmt.Fprintf(out, "Error opening history file: %s\n", err)
+				return 1
+			}
+			defer file.Close()
+			for i := hist.lastAppendedIndex; i < hist.Len(); i++ {
+				if command, exists := hist.Get(i); exists {
+					fmt.Fprintln(file, command)
+				}
+			}
+			hist.lastAppendedIndex = hist.Len()
+			return 0
+		}
+	}
+	if hist.Len() == 0 {
+		fmt.Fprintln(out, "No commands in history.")
+	} else {
+		if cnt != 0 {
+			cnt = hist.Len() - cnt
+		}
+		for i := cnt; i < hist.Len(); i++ {
+			if command, exists := hist.Get(i); exists {
+				fmt.Fprintf(out, "    %d %s\n", i+1, command)
+			}
+		}
+	}
+	return 0
+}
+
+func (sh *Interpreter) findBinInPath(bin string) (string, bool) {
+	return sh.findInPath(bin, true)
+}
+
+// findInPath looks for a regular file called name in the PATH directories,
+// one that may be executed if executable is set.
+func (sh *Interpreter) findInPath(name string, executable bool) (string, bool) {
+	paths := sh.getVar("PATH")
+	for _, path := range strings.Split(paths, ":") {
+		file := filepath.Join(path, name)
+		if !strings.Contains(file, "/") {
+			// An entry for the current directory has to stay one, or the
+			// result reads as a name to look up again
+			file = "./" + file
+		}
+		info, err := os.Stat(sh.resolve(file))
+		if err == nil && !info.IsDir() && (!executable || info.Mode()&0111 != 0) {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// lookupCommand finds cmd in PATH, or takes it as it is when it contains a
+// slash. The status is 0 when it was found, 126 when only a file without the
+// executable bit exists, and 127 otherwise.
+func (sh *Interpreter) lookupCommand(cmd string) (string, int) {
+	if strings.Contains(cmd, "/") {
+		info, err := os.Stat(sh.resolve(cmd))
+		if err != nil {
+			return "", 127
+		}
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return "", 126
+		}
+		return cmd, 0
+	}
+	if filePath, ok := sh.hashedCommand(cmd); ok {
+		return filePath, 0
+	}
+	if filePath, exists := sh.findBinInPath(cmd); exists {
+		sh.rememberCommand(cmd, filePath, 1)
+		return filePath, 0
+	}
+	for _, path := range strings.Split(sh.getVar("PATH"), ":") {
+		if info, err := os.Stat(sh.resolve(filepath.Join(path, cmd))); err == nil && info.Mode().IsRegular() {
+			return "", 126
+		}
+	}
+	return "", 127
+}
+
+func (sh *Interpreter) reportLookupFailure(out io.Writer, cmd string, status int) {
+	name := strings.TrimPrefix(cmd, "exec: ")
+	cmd = sh.location() + cmd
+	switch {
+	case status == 126 && isDir(sh.resolve(name)):
+		fmt.Fprintf(out, "%s: Is a directory\n", cmd)
+	case status == 126:
+		fmt.Fprintf(out, "%s: Permission denied\n", cmd)
+	case strings.Contains(name, "/"):
+		fmt.Fprintf(out, "%s: No such file or directory\n", cmd)
+	default:
+		fmt.Fprintf(out, "%s: command not found\n", cmd)
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+var errBadInterpreter = errors.New("bad interpreter: No such file or directory")
+
+// checkInterpreter explains the ENOENT from running path, a script whose #!
+// line names a program that doesn't exist. Other errors are returned as
+// they are.
+func checkInterpreter(path string, err error) error {
+	if !errors.Is(err, syscall.ENOENT) {
+		return err
+	}
+	f, ferr := os.Open(path)
+	if ferr != nil {
+		return err
+	}
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	f.Close()
+	interp, ok := strings.CutPrefix(line, "#!")
+	if fields := strings.Fields(interp); ok && len(fields) > 0 {
+		return fmt.Errorf("%s: %w", fields[0], errBadInterpreter)
+	}
+	return err
+}
+
+// exitStatus turns the result of running a command into a shell exit status:
+// 128+N when it was killed by signal N, 126 when it could not be executed,
+// and 127 when it was not found.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if errors.Is(err, errBadInterpreter) {
+		return 126
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return 127
+	}
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.ENOEXEC) {
+		return 126
+	}
+	return 1
+}